@@ -0,0 +1,153 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// stand-in driver shared by tests, records statements with args.
+// exec returns scripted errors in order, then result of handle if set,
+// query returns columns and data
+type fakeDriver struct {
+	mutex     *sync.Mutex
+	queries   []string
+	errs      []error
+	commits   int
+	rollbacks int
+	columns   []string
+	data      [][]driver.Value
+	// called before exec without lock, such as blocking it
+	hook func()
+	// called with lock held
+	handle func(query string, args []driver.Value) (driver.Result, error)
+}
+
+func newFakeDb(errs ...error) (*sql.DB, *fakeDriver) {
+	d := &fakeDriver{mutex: &sync.Mutex{}, errs: errs}
+	return sql.OpenDB(d), d
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	return &fakeConn{d: d}, nil
+}
+
+func (d *fakeDriver) Connect(ctx context.Context) (driver.Conn, error) {
+	return d.Open("")
+}
+
+func (d *fakeDriver) Driver() driver.Driver {
+	return d
+}
+
+type fakeConn struct {
+	d *fakeDriver
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{d: c.d, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.d.mutex.Lock()
+	c.d.commits++
+	c.d.mutex.Unlock()
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.d.mutex.Lock()
+	c.d.rollbacks++
+	c.d.mutex.Unlock()
+	return nil
+}
+
+type fakeStmt struct {
+	d     *fakeDriver
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mutex.Lock()
+	hook := s.d.hook
+	s.d.mutex.Unlock()
+	if hook != nil {
+		hook()
+	}
+
+	s.d.mutex.Lock()
+	defer s.d.mutex.Unlock()
+	s.d.queries = append(s.d.queries, fmt.Sprint(s.query, args))
+	if len(s.d.errs) > 0 {
+		err := s.d.errs[0]
+		s.d.errs = s.d.errs[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.d.handle != nil {
+		return s.d.handle(s.query, args)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mutex.Lock()
+	defer s.d.mutex.Unlock()
+	s.d.queries = append(s.d.queries, fmt.Sprint(s.query, args))
+	return &fakeRows{columns: s.d.columns, data: s.d.data}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	data    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.data) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.data[0])
+	r.data = r.data[1:]
+	return nil
+}
+
+type fakeResult struct {
+	lastID int64
+	rows   int64
+}
+
+func (r fakeResult) LastInsertId() (int64, error) {
+	return r.lastID, nil
+}
+
+func (r fakeResult) RowsAffected() (int64, error) {
+	return r.rows, nil
+}
@@ -0,0 +1,275 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// map struct fields to columns by tag `db:"col"` or `db:"col,omitempty"`.
+// untagged embedded structs are flattened, other untagged or "-" fields are ignored.
+// omitempty fields with zero value are not written.
+
+const tagName = "db"
+
+var (
+	ErrNeedStructPtr      = errors.New("need pointer to struct")
+	ErrNeedStructSlicePtr = errors.New("need pointer to slice of struct")
+	ErrNoMappedField      = errors.New("struct has no db field")
+	ErrDuplicateColumn    = errors.New("struct has duplicate db column")
+)
+
+type fieldInfo struct {
+	column    string
+	index     []int
+	omitempty bool
+}
+
+type structInfo struct {
+	fields   []fieldInfo
+	columns  []string
+	byColumn map[string]*fieldInfo
+	err      error // ErrDuplicateColumn if two fields map to one column
+}
+
+var (
+	structInfoLock  = &sync.RWMutex{}
+	structInfoCache = make(map[reflect.Type]*structInfo)
+)
+
+func getStructInfo(t reflect.Type) (*structInfo, error) {
+	structInfoLock.RLock()
+	info, ok := structInfoCache[t]
+	structInfoLock.RUnlock()
+	if ok {
+		return info, info.err
+	}
+
+	info = &structInfo{byColumn: make(map[string]*fieldInfo)}
+	collectFields(t, nil, info)
+	for i := range info.fields {
+		if _, ok := info.byColumn[info.fields[i].column]; ok {
+			info.err = ErrDuplicateColumn
+			break
+		}
+		info.columns = append(info.columns, info.fields[i].column)
+		info.byColumn[info.fields[i].column] = &info.fields[i]
+	}
+	structInfoLock.Lock()
+	structInfoCache[t] = info
+	structInfoLock.Unlock()
+	return info, info.err
+}
+
+func collectFields(t reflect.Type, index []int, info *structInfo) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, hasTag := f.Tag.Lookup(tagName)
+		idx := append(append([]int(nil), index...), i)
+		if !hasTag && f.Anonymous {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				// nil pointer to unexported struct could not be allocated
+				if f.PkgPath != "" {
+					continue
+				}
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				collectFields(ft, idx, info)
+			}
+			continue
+		}
+		if !hasTag || tag == "-" || f.PkgPath != "" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		fi := fieldInfo{column: parts[0], index: idx}
+		if fi.column == "" {
+			continue
+		}
+		for _, opt := range parts[1:] {
+			if opt == "omitempty" {
+				fi.omitempty = true
+			}
+		}
+		info.fields = append(info.fields, fi)
+	}
+}
+
+// get field by index path, nil embedded pointer is allocated if alloc is true,
+// otherwise ok is false
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func structValue(src interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(src)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return v, ErrNeedStructPtr
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return v, ErrNeedStructPtr
+	}
+	return v, nil
+}
+
+// columns and values to write, zero omitempty fields are skipped
+func structFields(src interface{}) ([]string, []interface{}, error) {
+	v, err := structValue(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := getStructInfo(v.Type())
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := make([]string, 0, len(info.fields))
+	values := make([]interface{}, 0, len(info.fields))
+	for _, f := range info.fields {
+		fv, ok := fieldByIndex(v, f.index, false)
+		if !ok || (f.omitempty && fv.IsZero()) {
+			continue
+		}
+		columns = append(columns, f.column)
+		values = append(values, fv.Interface())
+	}
+	if len(columns) == 0 {
+		return nil, nil, ErrNoMappedField
+	}
+	return columns, values, nil
+}
+
+func InsertStruct(ctx context.Context, e Executor, table *string, src interface{}) (sql.Result, error) {
+	columns, values, err := structFields(src)
+	if err != nil {
+		return nil, err
+	}
+	return InsertContext(ctx, e, table, columns, values...)
+}
+
+// set columns of struct where conditions, condValues are values of conditions
+func UpdateStruct(ctx context.Context, e Executor, table *string, src interface{}, conditions []string,
+	condValues ...interface{}) (sql.Result, error) {
+	columns, values, err := structFields(src)
+	if err != nil {
+		return nil, err
+	}
+	return UpdateContext(ctx, e, table, columns, conditions, append(values, condValues...)...)
+}
+
+// scan current row into struct v by column names, unknown columns are discarded
+func scanStruct(rows *sql.Rows, columns []string, info *structInfo, v reflect.Value) error {
+	dest := make([]interface{}, len(columns))
+	for i, col := range columns {
+		f, ok := info.byColumn[col]
+		if !ok {
+			dest[i] = new(sql.RawBytes)
+			continue
+		}
+		fv, _ := fieldByIndex(v, f.index, true)
+		dest[i] = fv.Addr().Interface()
+	}
+	return rows.Scan(dest...)
+}
+
+// select columns of struct into dest, which is pointer to slice of struct or struct pointer.
+// without conditions at most 1000 rows are selected, as buildSelectSql appends 'limit 1000'
+func SelectInto(ctx context.Context, e Executor, dest interface{}, table *string, conditions []string,
+	values ...interface{}) error {
+	sv := reflect.ValueOf(dest)
+	if sv.Kind() != reflect.Ptr || sv.IsNil() || sv.Elem().Kind() != reflect.Slice {
+		return ErrNeedStructSlicePtr
+	}
+	sv = sv.Elem()
+	elemType := sv.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	structType := elemType
+	if isPtr {
+		structType = elemType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return ErrNeedStructSlicePtr
+	}
+	info, err := getStructInfo(structType)
+	if err != nil {
+		return err
+	}
+	if len(info.columns) == 0 {
+		return ErrNoMappedField
+	}
+
+	rows, err := SelectContext(ctx, e, table, info.columns, conditions, values...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		item := reflect.New(structType)
+		if err := scanStruct(rows, columns, info, item.Elem()); err != nil {
+			return err
+		}
+		if isPtr {
+			sv.Set(reflect.Append(sv, item))
+		} else {
+			sv.Set(reflect.Append(sv, item.Elem()))
+		}
+	}
+	return rows.Err()
+}
+
+// select the first row into struct pointer dest, return sql.ErrNoRows if no row.
+// without conditions the query has 'limit 1000' as SelectInto
+func SelectOneInto(ctx context.Context, e Executor, dest interface{}, table *string, conditions []string,
+	values ...interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return ErrNeedStructPtr
+	}
+	info, err := getStructInfo(v.Elem().Type())
+	if err != nil {
+		return err
+	}
+	if len(info.columns) == 0 {
+		return ErrNoMappedField
+	}
+
+	rows, err := SelectContext(ctx, e, table, info.columns, conditions, values...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	return scanStruct(rows, columns, info, v.Elem())
+}
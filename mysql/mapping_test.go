@@ -0,0 +1,143 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testBase struct {
+	ID      int64     `db:"id,omitempty"`
+	Created time.Time `db:"created,omitempty"`
+}
+
+type Extra struct {
+	Score float64 `db:"score"`
+}
+
+type testHidden struct {
+	Hidden string `db:"hidden"`
+}
+
+type testUser struct {
+	*testHidden
+	testBase
+	*Extra
+	Name     string         `db:"name"`
+	Nick     sql.NullString `db:"nick,omitempty"`
+	Age      sql.NullInt64  `db:"age"`
+	Password string         `db:"-"`
+	Note     string
+	internal string `db:"internal"`
+}
+
+func TestStructInfo(t *testing.T) {
+	info, err := getStructInfo(reflect.TypeOf(testUser{}))
+	if err != nil || fmt.Sprint(info.columns) != "[id created score name nick age]" {
+		t.Log(info.columns, err)
+		t.Fail()
+	}
+	if cached, _ := getStructInfo(reflect.TypeOf(testUser{})); cached != info {
+		t.Fail()
+	}
+
+	type dup struct {
+		testBase
+		OtherID int64 `db:"id"`
+	}
+	if _, err := getStructInfo(reflect.TypeOf(dup{})); err != ErrDuplicateColumn {
+		t.Log(err)
+		t.Fail()
+	}
+	var dups []dup
+	if err := SelectInto(context.Background(), nil, &dups, nil, nil); err != ErrDuplicateColumn {
+		t.Log(err)
+		t.Fail()
+	}
+}
+
+func TestInsertUpdateStruct(t *testing.T) {
+	db, d := newFakeDb()
+	defer db.Close()
+	ctx := context.Background()
+	table := "user"
+	u := &testUser{Name: "a", Age: sql.NullInt64{Int64: 10, Valid: true}}
+	if _, err := InsertStruct(ctx, db, &table, u); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+	u.ID = 1
+	u.Extra = &Extra{Score: 1.5}
+	u.Nick = sql.NullString{String: "n", Valid: true}
+	if _, err := UpdateStruct(ctx, db, &table, u, []string{"id=?"}, u.ID); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+	expect := []string{
+		"insert into user (name,age) values (?,?)[a 10]",
+		"update user set id=?,score=?,name=?,nick=?,age=? where id=?[1 1.5 a n 10 1]",
+	}
+	if fmt.Sprint(d.queries) != fmt.Sprint(expect) {
+		t.Log(d.queries)
+		t.Fail()
+	}
+	if _, err := InsertStruct(ctx, db, &table, 1); err != ErrNeedStructPtr {
+		t.Fail()
+	}
+	if _, err := InsertStruct(ctx, db, &table, &struct{ A int }{}); err != ErrNoMappedField {
+		t.Fail()
+	}
+}
+
+func TestSelectInto(t *testing.T) {
+	db, d := newFakeDb()
+	defer db.Close()
+	ctx := context.Background()
+	table := "user"
+	now := time.Now()
+	d.columns = []string{"id", "created", "score", "name", "nick", "age", "unknown"}
+	d.data = [][]driver.Value{
+		{int64(1), now, 2.5, "a", nil, int64(10), "x"},
+		{int64(2), now, 3.5, "b", "bb", nil, "y"},
+	}
+	var users []testUser
+	if err := SelectInto(ctx, db, &users, &table, []string{"age>?"}, 1); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if d.queries[0] != "select id,created,score,name,nick,age from user where age>?[1]" {
+		t.Log(d.queries)
+		t.Fail()
+	}
+	if len(users) != 2 || users[0].ID != 1 || !users[0].Created.Equal(now) || users[0].Score != 2.5 ||
+		users[0].Nick.Valid || users[0].Age.Int64 != 10 || users[1].Nick.String != "bb" || users[1].Age.Valid {
+		t.Log(users)
+		t.Fail()
+	}
+
+	d.data = [][]driver.Value{{int64(3), now, 1.0, "c", nil, nil, nil}}
+	var ptrs []*testUser
+	if err := SelectInto(ctx, db, &ptrs, &table, nil); err != nil || len(ptrs) != 1 || ptrs[0].Name != "c" {
+		t.Log(ptrs, err)
+		t.Fail()
+	}
+	if err := SelectInto(ctx, db, users, &table, nil); err != ErrNeedStructSlicePtr {
+		t.Fail()
+	}
+
+	d.data = [][]driver.Value{{int64(4), now, 1.0, "d", nil, nil, nil}}
+	var u testUser
+	if err := SelectOneInto(ctx, db, &u, &table, []string{"id=?"}, 4); err != nil || u.ID != 4 || u.Name != "d" {
+		t.Log(u, err)
+		t.Fail()
+	}
+	d.data = nil
+	if err := SelectOneInto(ctx, db, &u, &table, []string{"id=?"}, 5); err != sql.ErrNoRows {
+		t.Log(err)
+		t.Fail()
+	}
+}
@@ -16,3 +16,12 @@ func TestOpenMysql(t *testing.T) {
 	_, err := db.Exec("show tables;")
 	t.Log(err)
 }
+
+func TestBuildUpdateSql(t *testing.T) {
+	table := "t"
+	sqlStr, err := buildUpdateSql(&table, []string{"a", "b", "c"}, []string{"id=?"})
+	if err != nil || *sqlStr != "update t set a=?,b=?,c=? where id=?" {
+		t.Log(*sqlStr, err)
+		t.Fail()
+	}
+}
@@ -1,6 +1,7 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -28,6 +29,19 @@ var (
 	SqlErrNeedFields     = errors.New("need fields")
 )
 
+// run sql on db, transaction or single connection, satisfied by *sql.DB, *sql.Tx and *sql.Conn
+type Executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+var (
+	_ Executor = (*sql.DB)(nil)
+	_ Executor = (*sql.Tx)(nil)
+	_ Executor = (*sql.Conn)(nil)
+)
+
 // max n=32
 func prepareStrLen(n int) int {
 	if n <= 0 && n > maxFields {
@@ -52,11 +66,15 @@ func buildInsertSql(table *string, fields []string, valuesLen int) (*string, err
 
 // max field number:32
 func Insert(db *sql.DB, table *string, fields []string, values ...interface{}) (sql.Result, error) {
+	return InsertContext(context.Background(), db, table, fields, values...)
+}
+
+func InsertContext(ctx context.Context, e Executor, table *string, fields []string, values ...interface{}) (sql.Result, error) {
 	sqlStr, err := buildInsertSql(table, fields, len(values))
 	if err != nil {
 		return nil, err
 	}
-	return db.Exec(*sqlStr, values...)
+	return e.ExecContext(ctx, *sqlStr, values...)
 }
 
 // if len(fields)==0, equal select *
@@ -86,21 +104,31 @@ func buildSelectSql(table *string, fields []string, conditions []string) (*strin
 
 // should: len(values)==len(conditions)
 func Select(db *sql.DB, table *string, fields []string, conditions []string, values ...interface{}) (*sql.Rows, error) {
+	return SelectContext(context.Background(), db, table, fields, conditions, values...)
+}
+
+func SelectContext(ctx context.Context, e Executor, table *string, fields []string, conditions []string,
+	values ...interface{}) (*sql.Rows, error) {
 	sqlStr, err := buildSelectSql(table, fields, conditions)
 	if err != nil {
 		return nil, err
 	}
-	return db.Query(*sqlStr, values...)
+	return e.QueryContext(ctx, *sqlStr, values...)
 }
 
 // should: len(values)==len(conditions)
 // just return one row
 func SelectRow(db *sql.DB, table *string, fields []string, conditions []string, values ...interface{}) *sql.Row {
+	return SelectRowContext(context.Background(), db, table, fields, conditions, values...)
+}
+
+func SelectRowContext(ctx context.Context, e Executor, table *string, fields []string, conditions []string,
+	values ...interface{}) *sql.Row {
 	sqlStr, err := buildSelectSql(table, fields, conditions)
 	if err != nil {
 		return nil
 	}
-	return db.QueryRow(*sqlStr, values...)
+	return e.QueryRowContext(ctx, *sqlStr, values...)
 }
 
 // if len(conditions)==0, return error
@@ -120,11 +148,15 @@ func buildDeleteSql(table *string, conditions []string) (*string, error) {
 
 // should: len(values)==len(conditions)
 func Delete(db *sql.DB, table *string, conditions []string, values ...interface{}) (sql.Result, error) {
+	return DeleteContext(context.Background(), db, table, conditions, values...)
+}
+
+func DeleteContext(ctx context.Context, e Executor, table *string, conditions []string, values ...interface{}) (sql.Result, error) {
 	sqlStr, err := buildDeleteSql(table, conditions)
 	if err != nil {
 		return nil, err
 	}
-	return db.Exec(*sqlStr, values...)
+	return e.ExecContext(ctx, *sqlStr, values...)
 }
 
 // if len(fields)==0, return error
@@ -149,9 +181,14 @@ func buildUpdateSql(table *string, fields []string, conditions []string) (*strin
 
 // args: field value and condition value
 func Update(db *sql.DB, table *string, fields []string, conditions []string, args ...interface{}) (sql.Result, error) {
+	return UpdateContext(context.Background(), db, table, fields, conditions, args...)
+}
+
+func UpdateContext(ctx context.Context, e Executor, table *string, fields []string, conditions []string,
+	args ...interface{}) (sql.Result, error) {
 	sqlStr, err := buildUpdateSql(table, fields, conditions)
 	if err != nil {
 		return nil, err
 	}
-	return db.Exec(*sqlStr, args...)
+	return e.ExecContext(ctx, *sqlStr, args...)
 }
@@ -0,0 +1,172 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// segment id allocator like Meituan Leaf, every biz tag has one row in segment table:
+//   create table id_segment (
+//     biz_tag varchar(128) not null primary key,
+//     max_id bigint not null default 0
+//   );
+// process leases ids in (max_id, max_id+step] by
+//   update id_segment set max_id=last_insert_id(max_id+?) where biz_tag=?
+// and reads new max_id from LastInsertId of the same statement, so no transaction is needed.
+// next segment is loaded in background before current segment runs out.
+
+const (
+	DefaultSegmentTable  = "id_segment"
+	DefaultSegmentStep   = 1000
+	DefaultLoadThreshold = 0.9
+
+	// not built by Update, buildUpdateSql renders every field as "field=?"
+	// and can not express max_id=last_insert_id(max_id+?)
+	segmentFetchSql = `update %s set max_id=last_insert_id(max_id+?) where biz_tag=?`
+)
+
+var (
+	ErrUnknownBizTag  = errors.New("segment biz tag not exist")
+	ErrInvalidSegment = errors.New("segment invalid max id")
+)
+
+type SegmentOption struct {
+	Table string // default DefaultSegmentTable
+	Step  int64  // default DefaultSegmentStep
+	// next segment is loaded once remaining ids of current segment are less than Step*LoadThreshold
+	LoadThreshold float64 // default DefaultLoadThreshold
+}
+
+type SegmentAllocator struct {
+	db       *sql.DB
+	opt      SegmentOption
+	fetchSql string
+	mutex    *sync.Mutex
+	buffers  map[string]*segmentBuffer
+}
+
+// ids in [next, max]
+type segment struct {
+	next int64
+	max  int64
+}
+
+// current segment and preloaded next segment of one biz tag
+type segmentBuffer struct {
+	mutex   *sync.Mutex
+	cur     *segment
+	next    *segment
+	loading chan struct{} // closed when loading done, nil if not loading
+	loadErr error
+}
+
+func NewSegmentAllocator(db *sql.DB, opt SegmentOption) *SegmentAllocator {
+	if opt.Table == "" {
+		opt.Table = DefaultSegmentTable
+	}
+	if opt.Step <= 0 {
+		opt.Step = DefaultSegmentStep
+	}
+	if opt.LoadThreshold <= 0 {
+		opt.LoadThreshold = DefaultLoadThreshold
+	}
+	return &SegmentAllocator{
+		db:       db,
+		opt:      opt,
+		fetchSql: fmt.Sprintf(segmentFetchSql, opt.Table),
+		mutex:    &sync.Mutex{},
+		buffers:  make(map[string]*segmentBuffer),
+	}
+}
+
+// insert biz tag row, ids of the tag start from maxID+1
+func (a *SegmentAllocator) CreateBizTag(tag string, maxID int64) error {
+	_, err := Insert(a.db, &a.opt.Table, []string{"biz_tag", "max_id"}, tag, maxID)
+	return err
+}
+
+func (a *SegmentAllocator) buffer(tag string) *segmentBuffer {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	buf, ok := a.buffers[tag]
+	if !ok {
+		buf = &segmentBuffer{mutex: &sync.Mutex{}}
+		a.buffers[tag] = buf
+	}
+	return buf
+}
+
+// lease next segment of tag from db
+func (a *SegmentAllocator) fetch(tag string) (*segment, error) {
+	res, err := a.db.Exec(a.fetchSql, a.opt.Step, tag)
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrUnknownBizTag
+	}
+	max, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	if max < a.opt.Step {
+		return nil, ErrInvalidSegment
+	}
+	return &segment{next: max - a.opt.Step + 1, max: max}, nil
+}
+
+// start loading next segment, should be called with buf.mutex held
+func (a *SegmentAllocator) load(tag string, buf *segmentBuffer) {
+	done := make(chan struct{})
+	buf.loading = done
+	go func() {
+		seg, err := a.fetch(tag)
+		buf.mutex.Lock()
+		buf.next, buf.loadErr = seg, err
+		buf.loading = nil
+		buf.mutex.Unlock()
+		close(done)
+	}()
+}
+
+// return next id of tag, block only when both current and next segments run out
+func (a *SegmentAllocator) Next(tag string) (int64, error) {
+	buf := a.buffer(tag)
+	buf.mutex.Lock()
+	for {
+		if cur := buf.cur; cur != nil && cur.next <= cur.max {
+			id := cur.next
+			cur.next++
+			remain := cur.max - id
+			if buf.next == nil && buf.loading == nil && float64(remain) < float64(a.opt.Step)*a.opt.LoadThreshold {
+				a.load(tag, buf)
+			}
+			buf.mutex.Unlock()
+			return id, nil
+		}
+		if buf.next != nil {
+			buf.cur, buf.next = buf.next, nil
+			continue
+		}
+		if buf.loading == nil {
+			buf.loadErr = nil
+			a.load(tag, buf)
+		}
+		done := buf.loading
+		buf.mutex.Unlock()
+		<-done
+		buf.mutex.Lock()
+		if buf.next == nil && buf.loadErr != nil {
+			err := buf.loadErr
+			buf.loadErr = nil
+			buf.mutex.Unlock()
+			return 0, err
+		}
+	}
+}
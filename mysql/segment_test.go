@@ -0,0 +1,198 @@
+package mysql
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+// in-memory stand-in of segment table on fakeDriver, only supports statements used by SegmentAllocator
+type segmentTable struct {
+	*fakeDriver
+	rows    map[string]int64
+	updates int
+	err     error
+}
+
+func newSegmentDb() (*sql.DB, *segmentTable) {
+	db, d := newFakeDb()
+	tb := &segmentTable{fakeDriver: d, rows: make(map[string]int64)}
+	d.handle = tb.exec
+	return db, tb
+}
+
+// called with driver lock held
+func (tb *segmentTable) exec(query string, args []driver.Value) (driver.Result, error) {
+	if tb.err != nil {
+		return nil, tb.err
+	}
+	switch query {
+	case "insert into id_segment (biz_tag,max_id) values (?,?)":
+		tag := args[0].(string)
+		if _, ok := tb.rows[tag]; ok {
+			return nil, errors.New("duplicate entry")
+		}
+		tb.rows[tag] = args[1].(int64)
+		return driver.RowsAffected(1), nil
+	case "update id_segment set max_id=last_insert_id(max_id+?) where biz_tag=?":
+		tag := args[1].(string)
+		max, ok := tb.rows[tag]
+		if !ok {
+			return fakeResult{}, nil
+		}
+		max += args[0].(int64)
+		tb.rows[tag] = max
+		tb.updates++
+		return fakeResult{lastID: max, rows: 1}, nil
+	}
+	return nil, errors.New("unsupported query: " + query)
+}
+
+// wait for background loading of tag done
+func waitSegmentLoaded(a *SegmentAllocator, tag string) {
+	buf := a.buffer(tag)
+	buf.mutex.Lock()
+	done := buf.loading
+	buf.mutex.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+func TestSegmentAllocator(t *testing.T) {
+	db, d := newSegmentDb()
+	defer db.Close()
+	a := NewSegmentAllocator(db, SegmentOption{Step: 10, LoadThreshold: 0.5})
+	if _, err := a.Next("none"); err != ErrUnknownBizTag {
+		t.Log(err)
+		t.Fail()
+	}
+	if err := a.CreateBizTag("order", 100); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	a.CreateBizTag("user", 0)
+
+	n := 8
+	results := make(chan []int64, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			ids := make([]int64, 0, 100)
+			for j := 0; j < 100; j++ {
+				id, err := a.Next("order")
+				if err != nil {
+					t.Log(err)
+					t.Fail()
+				}
+				ids = append(ids, id)
+			}
+			results <- ids
+		}()
+	}
+	seen := make(map[int64]bool)
+	for i := 0; i < n; i++ {
+		ids := <-results
+		for j, id := range ids {
+			if seen[id] || id <= 100 || (j > 0 && id <= ids[j-1]) {
+				t.Log("unexpected id", id)
+				t.FailNow()
+			}
+			seen[id] = true
+		}
+	}
+	if id, err := a.Next("user"); err != nil || id != 1 {
+		t.Log(id, err)
+		t.Fail()
+	}
+
+	// next segment is preloaded, failure of db is not noticed until it runs out
+	other := NewSegmentAllocator(db, SegmentOption{Step: 10, LoadThreshold: 0.5})
+	for i := 0; i < 6; i++ {
+		other.Next("user")
+	}
+	waitSegmentLoaded(other, "user")
+	d.mutex.Lock()
+	d.err = errors.New("db down")
+	d.mutex.Unlock()
+	for i := 0; i < 14; i++ {
+		if id, err := other.Next("user"); err != nil || id != int64(17+i) {
+			t.Log(id, err)
+			t.Fail()
+		}
+	}
+	if _, err := other.Next("user"); err == nil {
+		t.Fail()
+	}
+	d.mutex.Lock()
+	d.err = nil
+	d.mutex.Unlock()
+	if id, err := other.Next("user"); err != nil || id != 31 {
+		t.Log(id, err)
+		t.Fail()
+	}
+}
+
+func TestSegmentPreload(t *testing.T) {
+	db, d := newSegmentDb()
+	defer db.Close()
+	a := NewSegmentAllocator(db, SegmentOption{Step: 100})
+	a.CreateBizTag("preload", 0)
+	if id, err := a.Next("preload"); err != nil || id != 1 {
+		t.Log(id, err)
+		t.FailNow()
+	}
+
+	// block loading of next segment until all ids of current segment are used
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	d.mutex.Lock()
+	d.hook = func() {
+		started <- struct{}{}
+		<-release
+	}
+	d.mutex.Unlock()
+
+	consumed := make(chan struct{})
+	go func() {
+		defer close(consumed)
+		for i := 2; i <= 100; i++ {
+			if id, err := a.Next("preload"); err != nil || id != int64(i) {
+				t.Log(id, err)
+				t.Fail()
+			}
+		}
+	}()
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Log("next segment not preloaded")
+		t.Fail()
+	}
+	select {
+	case <-consumed:
+	case <-time.After(time.Second):
+		t.Log("blocked by loading segment")
+		t.Fail()
+	}
+	close(release)
+	<-consumed
+	waitSegmentLoaded(a, "preload")
+
+	d.mutex.Lock()
+	d.hook = nil
+	d.mutex.Unlock()
+	for i := 101; i <= 110; i++ {
+		if id, err := a.Next("preload"); err != nil || id != int64(i) {
+			t.Log(id, err)
+			t.Fail()
+		}
+	}
+	d.mutex.Lock()
+	if d.updates != 2 {
+		t.Log(d.updates)
+		t.Fail()
+	}
+	d.mutex.Unlock()
+}
@@ -0,0 +1,80 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	_mysql "github.com/go-sql-driver/mysql"
+)
+
+const (
+	errLockDeadlock    = 1213
+	errLockWaitTimeout = 1205
+
+	DefaultTxRetry      = 3
+	DefaultTxRetryDelay = 10 * time.Millisecond
+)
+
+type TxOption struct {
+	TxOptions  *sql.TxOptions
+	MaxRetry   int           // retry times on deadlock or lock wait timeout, default DefaultTxRetry
+	RetryDelay time.Duration // delay before the nth retry is n*RetryDelay, default DefaultTxRetryDelay
+}
+
+// deadlock and lock wait timeout could succeed on retry
+func isRetryableTxError(err error) bool {
+	var me *_mysql.MySQLError
+	if !errors.As(err, &me) {
+		return false
+	}
+	return me.Number == errLockDeadlock || me.Number == errLockWaitTimeout
+}
+
+// run fn in transaction with default TxOption
+func WithTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	return WithTxOption(ctx, db, TxOption{}, fn)
+}
+
+// commit if fn returns nil, otherwise rollback and return error of fn.
+// panic in fn is recovered to rollback and then panics again.
+// the whole transaction is retried on deadlock or lock wait timeout, so fn should be idempotent
+// besides the transaction, such as not sending messages.
+func WithTxOption(ctx context.Context, db *sql.DB, opt TxOption, fn func(tx *sql.Tx) error) error {
+	if opt.MaxRetry <= 0 {
+		opt.MaxRetry = DefaultTxRetry
+	}
+	if opt.RetryDelay <= 0 {
+		opt.RetryDelay = DefaultTxRetryDelay
+	}
+	for i := 0; ; i++ {
+		err := runTx(ctx, db, opt.TxOptions, fn)
+		if err == nil || i >= opt.MaxRetry || !isRetryableTxError(err) {
+			return err
+		}
+		select {
+		case <-time.After(opt.RetryDelay * time.Duration(i+1)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func runTx(ctx context.Context, db *sql.DB, opts *sql.TxOptions, fn func(tx *sql.Tx) error) (err error) {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
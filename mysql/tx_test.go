@@ -0,0 +1,116 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	_mysql "github.com/go-sql-driver/mysql"
+)
+
+func TestContextHelpers(t *testing.T) {
+	db, d := newFakeDb()
+	defer db.Close()
+	ctx := context.Background()
+	table := "t"
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.FailNow()
+	}
+	defer conn.Close()
+	InsertContext(ctx, conn, &table, []string{"a", "b"}, 1, 2)
+	UpdateContext(ctx, db, &table, []string{"a"}, []string{"b=?"}, 3, 2)
+	DeleteContext(ctx, db, &table, []string{"a=?"}, 3)
+	if _, err := DeleteContext(ctx, db, &table, nil); err != SqlErrNeedConditions {
+		t.Fail()
+	}
+	expect := []string{
+		"insert into t (a,b) values (?,?)[1 2]",
+		"update t set a=? where b=?[3 2]",
+		"delete from t where a=?[3]",
+	}
+	if fmt.Sprint(d.queries) != fmt.Sprint(expect) {
+		t.Log(d.queries)
+		t.Fail()
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := InsertContext(cancelled, db, &table, []string{"a"}, 1); err != context.Canceled {
+		t.Log(err)
+		t.Fail()
+	}
+}
+
+func TestWithTx(t *testing.T) {
+	deadlock := &_mysql.MySQLError{Number: errLockDeadlock, Message: "deadlock"}
+	lockWait := &_mysql.MySQLError{Number: errLockWaitTimeout, Message: "lock wait timeout"}
+	db, d := newFakeDb(deadlock, nil, lockWait)
+	defer db.Close()
+	table := "t"
+	calls := 0
+	err := WithTx(context.Background(), db, func(tx *sql.Tx) error {
+		calls++
+		if _, err := InsertContext(context.Background(), tx, &table, []string{"a"}, calls); err != nil {
+			return err
+		}
+		if _, err := InsertContext(context.Background(), tx, &table, []string{"b"}, calls); err != nil {
+			return fmt.Errorf("insert b: %w", err)
+		}
+		return nil
+	})
+	if err != nil || calls != 3 || d.commits != 1 || d.rollbacks != 2 {
+		t.Log(err, calls, d.commits, d.rollbacks)
+		t.Fail()
+	}
+
+	db, d = newFakeDb(deadlock, deadlock)
+	defer db.Close()
+	calls = 0
+	err = WithTxOption(context.Background(), db, TxOption{MaxRetry: 1}, func(tx *sql.Tx) error {
+		calls++
+		_, err := InsertContext(context.Background(), tx, &table, []string{"a"}, calls)
+		return err
+	})
+	if err != deadlock || calls != 2 || d.rollbacks != 2 {
+		t.Log(err, calls, d.rollbacks)
+		t.Fail()
+	}
+
+	other := errors.New("other")
+	calls = 0
+	err = WithTx(context.Background(), db, func(tx *sql.Tx) error {
+		calls++
+		return other
+	})
+	if err != other || calls != 1 {
+		t.Log(err, calls)
+		t.Fail()
+	}
+
+	err = WithTx(context.Background(), db, func(tx *sql.Tx) error {
+		_, err := InsertContext(context.Background(), tx, &table, []string{"a"}, 1)
+		return err
+	})
+	if err != nil || d.commits != 1 {
+		t.Log(err, d.commits)
+		t.Fail()
+	}
+}
+
+func TestWithTxPanic(t *testing.T) {
+	db, d := newFakeDb()
+	defer db.Close()
+	defer func() {
+		if p := recover(); p != "boom" || d.rollbacks != 1 || d.commits != 0 {
+			t.Log(p, d.rollbacks, d.commits)
+			t.Fail()
+		}
+	}()
+	WithTx(context.Background(), db, func(tx *sql.Tx) error {
+		panic("boom")
+	})
+	t.Fail()
+}
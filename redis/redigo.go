@@ -59,9 +59,15 @@ type RedisDbConf struct {
 	Timeout  time.Duration `json:"timeout"`
 }
 
+// get connection by key, implemented by zk.HashGetter and zk.ConsistentHashGetter
+type keyConnGetter interface {
+	GetConn(key []byte) zk.ServiceConn
+	GetConnAddr(key []byte) (zk.ServiceConn, string)
+}
+
 type ZkRedisCli struct {
 	*zk.ZKMonitor
-	hashGetter  *zk.HashGetter
+	hashGetter  keyConnGetter
 	roundGetter *zk.RoundTripGetter
 }
 
@@ -81,6 +87,8 @@ func (cli *ZkRedisCli) UseRoundTripGet() {
 	cli.roundGetter = cli.RoundTripGetter()
 }
 
+// only the first call takes effect, and it is no-op after UseConsistentHashGet.
+// should be called before HashGet
 func (cli *ZkRedisCli) UseHashGet(hashFn func([]byte) uint32) {
 	if cli.hashGetter != nil {
 		return
@@ -88,28 +96,44 @@ func (cli *ZkRedisCli) UseHashGet(hashFn func([]byte) uint32) {
 	cli.hashGetter = cli.HashGetter(hashFn)
 }
 
+// HashGet use consistent hash ring, keys of unchanged address keep their address.
+// replace getter set by UseHashGet or last call, should be called before HashGet
+func (cli *ZkRedisCli) UseConsistentHashGet(opt zk.ConsistentHashOption) {
+	cli.hashGetter = cli.ConsistentHashGetter(opt)
+}
+
 // call redis.Conn Close after used
 func (cli *ZkRedisCli) RoundTripGet() redis.Conn {
+	conn, _ := cli.RoundTripGetAddr()
+	return conn
+}
+
+// same as RoundTripGet, also return redis address for ReportFailure and ReportSuccess
+func (cli *ZkRedisCli) RoundTripGetAddr() (redis.Conn, string) {
 	if cli.roundGetter == nil {
-		return nil
+		return nil, ""
 	}
-	v := cli.roundGetter.GetConn()
-	conn, ok := v.(redis.Conn)
-	if !ok {
-		return nil
-	}
-	return conn
+	return toRedisConn(cli.roundGetter.GetConnAddr())
 }
 
 // call redis.Conn Close after used
 func (cli *ZkRedisCli) HashGet(key []byte) redis.Conn {
+	conn, _ := cli.HashGetAddr(key)
+	return conn
+}
+
+// same as HashGet, also return redis address for ReportFailure and ReportSuccess
+func (cli *ZkRedisCli) HashGetAddr(key []byte) (redis.Conn, string) {
 	if cli.hashGetter == nil {
-		return nil
+		return nil, ""
 	}
-	v := cli.hashGetter.GetConn(key)
+	return toRedisConn(cli.hashGetter.GetConnAddr(key))
+}
+
+func toRedisConn(v zk.ServiceConn, addr string) (redis.Conn, string) {
 	conn, ok := v.(redis.Conn)
 	if !ok {
-		return nil
+		return nil, ""
 	}
-	return conn
+	return conn, addr
 }
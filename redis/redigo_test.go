@@ -1,18 +1,38 @@
 package redis
 
 import (
-	"testing"
-	"time"
-	"gopublic/zk"
 	"fmt"
+	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RivenZoo/goutil/zk"
+	"github.com/RivenZoo/goutil/zk/zktest"
 )
 
 var (
 	testServers = []string{"127.0.0.1:2181"}
 )
 
+// run tests with in-memory zookeeper server, unless ZK_SERVERS is set
+func TestMain(m *testing.M) {
+	if servers := os.Getenv("ZK_SERVERS"); servers != "" {
+		testServers = strings.Split(servers, ",")
+		os.Exit(m.Run())
+	}
+	srv, err := zktest.NewServer()
+	if err != nil {
+		panic(err)
+	}
+	testServers = srv.Servers()
+	code := m.Run()
+	srv.Close()
+	os.Exit(code)
+}
+
 func TestRedisGetConn(t *testing.T) {
 	redisAddr := "127.0.0.1:6379"
 	cli := zk.NewZKClient(testServers, time.Second*5, nil)
@@ -44,3 +64,47 @@ func TestRedisGetConn(t *testing.T) {
 	wg.Wait()
 	fmt.Println("nil conn:", cnt)
 }
+
+func TestUseHashGet(t *testing.T) {
+	mpath := "/test/redis-hash"
+	cli := zk.NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	rds := NewZKRedisCli(testServers, mpath, &RedisDbConf{Timeout: time.Second})
+	defer rds.Close()
+	if rds.HashGet([]byte("key")) != nil {
+		t.FailNow()
+	}
+
+	// first UseHashGet call wins, UseConsistentHashGet replaces it
+	rds.UseHashGet(nil)
+	first := rds.hashGetter
+	rds.UseHashGet(nil)
+	if _, ok := first.(*zk.HashGetter); !ok || rds.hashGetter != first {
+		t.Fail()
+	}
+	rds.UseConsistentHashGet(zk.ConsistentHashOption{})
+	rds.UseHashGet(nil)
+	if _, ok := rds.hashGetter.(*zk.ConsistentHashGetter); !ok {
+		t.Fail()
+	}
+
+	// connection is returned even though nothing listens on the address, dial error is kept in conn
+	rds.Run()
+	addr := "127.0.0.1:1"
+	if err := cli.CreateEphemeralNode(mpath + "/" + addr); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer cli.DeleteNode(mpath + "/" + addr)
+	for i := 0; ; i++ {
+		if conn := rds.HashGet([]byte("key")); conn != nil {
+			conn.Close()
+			break
+		}
+		if i >= 100 {
+			t.Log("no conn by hash get")
+			t.FailNow()
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
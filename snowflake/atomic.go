@@ -0,0 +1,132 @@
+package snowflake
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// lock free generator without pre generated buffer, ids always follow wall clock.
+// timestamp and sequence of last id are packed in one int64 state word updated by CAS.
+// rolled back clock is handled by policy of WorkerOption, while waiting reuses last timestamp
+// until sequence exhausted before sleeping.
+type AtomicIDGenerator struct {
+	state      int64 // timestamp since epoch << sequence bits | sequence
+	machine    int64
+	datacenter int64
+	epoch      int64
+	layout     BitLayout
+	policy     ClockPolicy
+	maxWait    int64 // milliseconds
+	now        func() int64
+}
+
+func NewAtomicIDGenerator(opt WorkerOption) (*AtomicIDGenerator, error) {
+	w, err := NewIdWorkerWithOption(opt)
+	if err != nil {
+		return nil, err
+	}
+	return &AtomicIDGenerator{
+		state:      -1 << w.layout.Sequence,
+		machine:    w.machine,
+		datacenter: w.datacenter,
+		epoch:      w.epoch,
+		layout:     w.layout,
+		policy:     w.policy,
+		maxWait:    int64(w.maxWait / time.Millisecond),
+		now:        nowMillis,
+	}, nil
+}
+
+func (g *AtomicIDGenerator) compose(ts, seq int64) int64 {
+	return ts<<g.layout.timestampShift() | g.datacenter<<g.layout.datacenterShift() |
+		g.machine<<g.layout.machineShift() | seq
+}
+
+// reserve at most n sequences in one millisecond, return timestamp, first sequence and count
+func (g *AtomicIDGenerator) reserve(n int64) (int64, int64, int64, error) {
+	seqMask := mask(g.layout.Sequence)
+	for {
+		old := atomic.LoadInt64(&g.state)
+		last, seq := old>>g.layout.Sequence, old&seqMask
+		now := g.now() - g.epoch
+		if now < last {
+			switch {
+			case g.policy == ClockError:
+				return 0, 0, 0, ErrClockRollback
+			case g.policy == ClockWait && last-now > g.maxWait:
+				return 0, 0, 0, ErrClockRollback
+			}
+		}
+
+		ts, start := now, int64(0)
+		if now <= last {
+			ts, start = last, seq+1
+			if start > seqMask {
+				if g.policy != ClockLogical {
+					if now < last {
+						// clock rolled back, sleep until it catches up like IdWorker
+						time.Sleep(time.Duration(last-now) * time.Millisecond)
+					} else {
+						// less than one millisecond left
+						runtime.Gosched()
+					}
+					continue
+				}
+				ts, start = last+1, 0
+			}
+		}
+		if ts < 0 || ts > mask(g.layout.Timestamp) {
+			return 0, 0, 0, ErrTimestampOverflow
+		}
+		count := seqMask - start + 1
+		if count > n {
+			count = n
+		}
+		if atomic.CompareAndSwapInt64(&g.state, old, ts<<g.layout.Sequence|(start+count-1)) {
+			return ts, start, count, nil
+		}
+	}
+}
+
+func (g *AtomicIDGenerator) Next() (int64, error) {
+	ts, seq, _, err := g.reserve(1)
+	if err != nil {
+		return 0, err
+	}
+	return g.compose(ts, seq), nil
+}
+
+// panic if Next returns error, never return 0
+func (g *AtomicIDGenerator) NextID() int64 {
+	id, err := g.Next()
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// return n ids in ascending order, ids are reserved in contiguous ranges,
+// each range takes sequences of one millisecond by one CAS.
+// return empty slice if n is 0, ErrInvalidCount if n is negative
+func (g *AtomicIDGenerator) NextN(n int) ([]int64, error) {
+	if n < 0 {
+		return nil, ErrInvalidCount
+	}
+	ids := make([]int64, 0, n)
+	for len(ids) < n {
+		ts, seq, count, err := g.reserve(int64(n - len(ids)))
+		if err != nil {
+			return nil, err
+		}
+		first := g.compose(ts, seq)
+		for i := int64(0); i < count; i++ {
+			ids = append(ids, first+i)
+		}
+	}
+	return ids, nil
+}
+
+func (g *AtomicIDGenerator) Decode(id int64) IDInfo {
+	return g.layout.Decode(id, g.epoch)
+}
@@ -0,0 +1,156 @@
+package snowflake
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAtomicIDGenerator_Next(t *testing.T) {
+	g, err := NewAtomicIDGenerator(WorkerOption{Machine: 3, Datacenter: 1})
+	if err != nil {
+		t.FailNow()
+	}
+	n := 8
+	results := make(chan []int64, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			ids := make([]int64, 0, 2000)
+			for j := 0; j < 1000; j++ {
+				if i%2 == 0 {
+					ids = append(ids, g.NextID())
+					continue
+				}
+				batch, err := g.NextN(3)
+				if err != nil {
+					t.Log(err)
+					t.Fail()
+				}
+				ids = append(ids, batch...)
+			}
+			results <- ids
+		}(i)
+	}
+	seen := make(map[int64]bool)
+	for i := 0; i < n; i++ {
+		ids := <-results
+		for j, id := range ids {
+			if seen[id] || id <= 0 {
+				t.Log("duplicate id", id)
+				t.FailNow()
+			}
+			if j > 0 && id <= ids[j-1] {
+				t.Log("id not ascending", ids[j-1], id)
+				t.FailNow()
+			}
+			if info := g.Decode(id); info.Machine != 3 || info.Datacenter != 1 {
+				t.Log(info)
+				t.FailNow()
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func TestAtomicIDGenerator_NextN(t *testing.T) {
+	g, _ := NewAtomicIDGenerator(WorkerOption{Layout: BitLayout{Timestamp: 41, Sequence: 2}})
+	clock := &testClock{t: 100}
+	g.now = clock.now
+	ids, err := g.NextN(3)
+	if err != nil || len(ids) != 3 || ids[0] != 100<<2 || ids[2] != 100<<2|2 {
+		t.Log(ids, err)
+		t.Fail()
+	}
+	go func() {
+		clock.set(101)
+	}()
+	ids, err = g.NextN(3)
+	if err != nil || len(ids) != 3 || ids[0] != 100<<2|3 || ids[1] != 101<<2 || ids[2] != 101<<2|1 {
+		t.Log(ids, err)
+		t.Fail()
+	}
+
+	clock.set(90)
+	g.policy = ClockError
+	if _, err := g.Next(); err != ErrClockRollback {
+		t.Log(err)
+		t.Fail()
+	}
+	g.policy = ClockLogical
+	ids, _ = g.NextN(3)
+	if ids[0] != 101<<2|2 || ids[2] != 102<<2 {
+		t.Log(ids)
+		t.Fail()
+	}
+	g.policy = ClockWait
+	g.maxWait = 5
+	if _, err := g.NextN(1); err != ErrClockRollback {
+		t.Fail()
+	}
+
+	if ids, err := g.NextN(0); err != nil || ids == nil || len(ids) != 0 {
+		t.Log(ids, err)
+		t.Fail()
+	}
+	if _, err := g.NextN(-1); err != ErrInvalidCount {
+		t.Log(err)
+		t.Fail()
+	}
+}
+
+func TestAtomicIDGenerator_ClockWait(t *testing.T) {
+	g, _ := NewAtomicIDGenerator(WorkerOption{Layout: BitLayout{Timestamp: 41, Sequence: 1}})
+	var calls int64
+	clock := &testClock{t: 100}
+	g.now = func() int64 {
+		atomic.AddInt64(&calls, 1)
+		return clock.now()
+	}
+	if _, err := g.NextN(2); err != nil {
+		t.FailNow()
+	}
+	// clock rolled back 20ms, sequence of last timestamp exhausted
+	clock.set(80)
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		clock.set(101)
+	}()
+	id, err := g.Next()
+	if err != nil || id != 101<<1 {
+		t.Log(id, err)
+		t.Fail()
+	}
+	// sleeps instead of spinning while clock is behind
+	if n := atomic.LoadInt64(&calls); n > 100 {
+		t.Log(n)
+		t.Fail()
+	}
+}
+
+func BenchmarkAtomicIDGenerator_NextID(b *testing.B) {
+	g, _ := NewAtomicIDGenerator(WorkerOption{})
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			id := g.NextID()
+			id += 1
+		}
+	})
+}
+
+func BenchmarkAtomicIDGenerator_NextN(b *testing.B) {
+	g, _ := NewAtomicIDGenerator(WorkerOption{})
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			g.NextN(16)
+		}
+	})
+}
+
+func BenchmarkIdWorker_Next(b *testing.B) {
+	w := NewIdWorker(0, 0, 0)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			w.Next()
+		}
+	})
+}
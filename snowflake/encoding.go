@@ -0,0 +1,81 @@
+package snowflake
+
+import (
+	"errors"
+)
+
+var ErrInvalidEncoding = errors.New("snowflake invalid encoded id")
+
+// fixed width encoding of id as uint64, alphabet is in ascii order and result is left padded
+// with the first letter, so encoded strings sort in the same order as non-negative ids
+type Encoding struct {
+	alphabet  string
+	width     int
+	decodeMap [256]int16
+}
+
+var (
+	// case insensitive on decode, and "I", "L" are read as "1", "O" as "0"
+	Base32Crockford = newEncoding("0123456789ABCDEFGHJKMNPQRSTVWXYZ", true, map[byte]byte{
+		'I': '1', 'L': '1', 'O': '0',
+	})
+	Base58 = newEncoding("123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz", false, nil)
+	Base62 = newEncoding("0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz", false, nil)
+)
+
+// alias maps extra upper case letter to letter of alphabet on decode
+func newEncoding(alphabet string, foldCase bool, alias map[byte]byte) *Encoding {
+	e := &Encoding{alphabet: alphabet}
+	for i := range e.decodeMap {
+		e.decodeMap[i] = -1
+	}
+	set := func(c byte, v int16) {
+		e.decodeMap[c] = v
+		if foldCase && c >= 'A' && c <= 'Z' {
+			e.decodeMap[c+'a'-'A'] = v
+		}
+	}
+	for i := 0; i < len(alphabet); i++ {
+		set(alphabet[i], int16(i))
+	}
+	for from, to := range alias {
+		set(from, e.decodeMap[to])
+	}
+	base := uint64(len(alphabet))
+	for v := ^uint64(0); v > 0; v /= base {
+		e.width++
+	}
+	return e
+}
+
+func (e *Encoding) Encode(id int64) string {
+	buf := make([]byte, e.width)
+	base := uint64(len(e.alphabet))
+	v := uint64(id)
+	for i := e.width - 1; i >= 0; i-- {
+		buf[i] = e.alphabet[v%base]
+		v /= base
+	}
+	return string(buf)
+}
+
+// padding is optional
+func (e *Encoding) Decode(s string) (int64, error) {
+	if len(s) == 0 || len(s) > e.width {
+		return 0, ErrInvalidEncoding
+	}
+	base := uint64(len(e.alphabet))
+	var v uint64
+	for i := 0; i < len(s); i++ {
+		d := e.decodeMap[s[i]]
+		if d < 0 {
+			return 0, ErrInvalidEncoding
+		}
+		next := v*base + uint64(d)
+		if v > (^uint64(0)-uint64(d))/base {
+			return 0, ErrInvalidEncoding
+		}
+		v = next
+	}
+	return int64(v), nil
+}
@@ -1,6 +1,9 @@
 package snowflake
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 const (
 	DefaultIDBufferSize = 256
@@ -9,18 +12,64 @@ const (
 type IDGenerator struct {
 	option IDGeneratorOption
 	idCh   chan int64
+	lost   <-chan struct{}        // nil if worker id is not leased
+	active func() <-chan struct{} // nil if lease is never paused
 	stop   chan struct{}
+	failed chan struct{} // closed after err set, no more id issued
+	err    error
 	wg     *sync.WaitGroup
 }
 
 type IDGeneratorOption struct {
-	BufferSize int   `json:"buffer_size"` // pre generate id number
-	Machine    int64 `json:"machine"`
-	Datacenter int64 `json:"datacenter"`
-	Epoch      int64 `json:"epoch"`
+	BufferSize  int           `json:"buffer_size"` // pre generate id number
+	Machine     int64         `json:"machine"`
+	Datacenter  int64         `json:"datacenter"`
+	Epoch       int64         `json:"epoch"`
+	Layout      BitLayout     `json:"layout"`       // zero means DefaultLayout
+	ClockPolicy ClockPolicy   `json:"clock_policy"` // default ClockWait
+	MaxWait     time.Duration `json:"max_wait"`     // used by ClockWait
+}
+
+func (opt IDGeneratorOption) workerOption() WorkerOption {
+	return WorkerOption{
+		Machine:     opt.Machine,
+		Datacenter:  opt.Datacenter,
+		Epoch:       opt.Epoch,
+		Layout:      opt.Layout,
+		ClockPolicy: opt.ClockPolicy,
+		MaxWait:     opt.MaxWait,
+	}
+}
+
+// machine and datacenter should fit in layout
+func (opt IDGeneratorOption) Validate() error {
+	return opt.workerOption().Validate()
 }
 
+// panic if option is invalid, use NewIDGeneratorE to check error
 func NewIDGenerator(opt IDGeneratorOption) *IDGenerator {
+	g, err := NewIDGeneratorE(opt)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// return Validate error if option is invalid
+func NewIDGeneratorE(opt IDGeneratorOption) (*IDGenerator, error) {
+	return newIDGenerator(opt, nil)
+}
+
+// lease is nil if worker id is not leased
+func newIDGenerator(opt IDGeneratorOption, lease Lease) (*IDGenerator, error) {
+	worker, err := NewIdWorkerWithOption(opt.workerOption())
+	if err != nil {
+		return nil, err
+	}
+	return startGenerator(opt, lease, worker), nil
+}
+
+func startGenerator(opt IDGeneratorOption, lease Lease, worker *IdWorker) *IDGenerator {
 	if opt.BufferSize <= 0 {
 		opt.BufferSize = DefaultIDBufferSize
 	}
@@ -28,29 +77,123 @@ func NewIDGenerator(opt IDGeneratorOption) *IDGenerator {
 		option: opt,
 		idCh:   make(chan int64, opt.BufferSize),
 		stop:   make(chan struct{}),
+		failed: make(chan struct{}),
 		wg:     &sync.WaitGroup{},
 	}
-	go runGenerator(g)
+	if lease != nil {
+		g.lost = lease.Lost()
+		if p, ok := lease.(PausableLease); ok {
+			g.active = p.Active
+		}
+	}
+	g.wg.Add(1)
+	go runGenerator(g, worker)
 	return g
 }
 
-func runGenerator(g *IDGenerator) {
-	g.wg.Add(1)
+// called once by runGenerator before it returns
+func (g *IDGenerator) fail(err error) {
+	g.err = err
+	close(g.failed)
+}
+
+func runGenerator(g *IDGenerator, worker *IdWorker) {
 	defer g.wg.Done()
 
-	worker := NewIdWorker(g.option.Machine, g.option.Datacenter, g.option.Epoch)
+	var retry *time.Timer
+	defer func() {
+		if retry != nil {
+			retry.Stop()
+		}
+	}()
 	for {
-		v := worker.Generate()
+		if g.active != nil {
+			select {
+			case <-g.stop:
+				return
+			case <-g.lost:
+				return
+			case <-g.active():
+			}
+		}
+		v, err := worker.Next()
+		if err == ErrClockRollback && g.option.ClockPolicy != ClockError {
+			// clock moved backwards more than MaxWait, retry later
+			if retry == nil {
+				retry = time.NewTimer(time.Millisecond)
+			} else {
+				retry.Reset(time.Millisecond)
+			}
+			select {
+			case <-g.stop:
+				return
+			case <-g.lost:
+				return
+			case <-retry.C:
+			}
+			continue
+		}
+		if err != nil {
+			// ClockError asks to fail at once, timestamp overflow never recovers
+			g.fail(err)
+			return
+		}
 		select {
 		case <-g.stop:
 			return
+		case <-g.lost:
+			return
 		case g.idCh <- v:
 		}
 	}
 }
 
+// panic if Next returns error, never return 0.
+//
+// Deprecated: use Next, which reports lease lost and generator failure.
 func (g *IDGenerator) NextID() int64 {
-	return <-g.idCh
+	id, err := g.Next()
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// return ErrLeaseLost once worker id lease lost, pre generated ids are dropped.
+// return the failure error once generator failed and pre generated ids are consumed.
+// block while lease is paused
+func (g *IDGenerator) Next() (int64, error) {
+	if g.active != nil {
+		select {
+		case <-g.lost:
+			return 0, ErrLeaseLost
+		case <-g.active():
+		}
+	}
+	select {
+	case id := <-g.idCh:
+		select {
+		case <-g.lost:
+			return 0, ErrLeaseLost
+		default:
+		}
+		return id, nil
+	case <-g.lost:
+		return 0, ErrLeaseLost
+	case <-g.failed:
+		// ids sent before failure are still buffered
+		select {
+		case <-g.lost:
+			return 0, ErrLeaseLost
+		default:
+		}
+		select {
+		case id := <-g.idCh:
+			return id, nil
+		default:
+		}
+		return 0, g.err
+	}
 }
 
 func (g *IDGenerator) Close() error {
@@ -0,0 +1,101 @@
+package snowflake
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidID = errors.New("snowflake invalid id string")
+
+// fields of id
+type IDInfo struct {
+	Time       time.Time
+	Timestamp  int64 // milliseconds since epoch
+	Datacenter int64
+	Machine    int64
+	Sequence   int64
+}
+
+// decode id with layout, epoch is milliseconds since unix epoch
+func (l BitLayout) Decode(id int64, epoch int64) IDInfo {
+	ts := (id >> l.timestampShift()) & mask(l.Timestamp)
+	ms := epoch + ts
+	return IDInfo{
+		Time:       time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond)),
+		Timestamp:  ts,
+		Datacenter: (id >> l.datacenterShift()) & mask(l.Datacenter),
+		Machine:    (id >> l.machineShift()) & mask(l.Machine),
+		Sequence:   id & mask(l.Sequence),
+	}
+}
+
+// decode id generated with DefaultLayout and zero epoch
+func Decode(id int64) IDInfo {
+	return DefaultLayout.Decode(id, 0)
+}
+
+func (w *IdWorker) Decode(id int64) IDInfo {
+	return w.layout.Decode(id, w.epoch)
+}
+
+// id marshalled as decimal string in json and text,
+// javascript number could not hold int64 without losing precision
+type ID int64
+
+func ParseID(s string) (ID, error) {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidID
+	}
+	return ID(v), nil
+}
+
+func (id ID) Int64() int64 {
+	return int64(id)
+}
+
+func (id ID) String() string {
+	return strconv.FormatInt(int64(id), 10)
+}
+
+func (id ID) Base32() string {
+	return Base32Crockford.Encode(int64(id))
+}
+
+func (id ID) Base58() string {
+	return Base58.Encode(int64(id))
+}
+
+func (id ID) Base62() string {
+	return Base62.Encode(int64(id))
+}
+
+func (id ID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+func (id *ID) UnmarshalText(b []byte) error {
+	v, err := ParseID(string(b))
+	if err != nil {
+		return err
+	}
+	*id = v
+	return nil
+}
+
+func (id ID) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(id.String())), nil
+}
+
+// accept both string and number
+func (id *ID) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		return nil
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	return id.UnmarshalText([]byte(s))
+}
@@ -0,0 +1,107 @@
+package snowflake
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	info := Decode(123<<22 | 7<<17 | 3<<12 | 9)
+	if info.Timestamp != 123 || info.Datacenter != 7 || info.Machine != 3 || info.Sequence != 9 ||
+		info.Time.UnixNano() != 123*1e6 {
+		t.Log(info)
+		t.Fail()
+	}
+
+	epoch := int64(1500000000000)
+	w := NewIdWorker(3, 7, epoch)
+	info = w.Decode(w.Generate())
+	if info.Machine != 3 || info.Datacenter != 7 || info.Time.UnixNano()/1e6 != epoch+info.Timestamp {
+		t.Log(info)
+		t.Fail()
+	}
+
+	layout := BitLayout{Timestamp: 40, Datacenter: 2, Machine: 8, Sequence: 10}
+	w, _ = NewIdWorkerWithOption(WorkerOption{Machine: 200, Datacenter: 3, Epoch: epoch, Layout: layout})
+	info = w.Decode(w.Generate())
+	if info.Machine != 200 || info.Datacenter != 3 || info.Sequence != 0 {
+		t.Log(info)
+		t.Fail()
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	ids := []int64{0, 1, 57, 58, 61, 62, 1 << 22, 7517548150408609792, math.MaxInt64}
+	for _, enc := range []*Encoding{Base32Crockford, Base58, Base62} {
+		strs := make([]string, len(ids))
+		for i, id := range ids {
+			strs[i] = enc.Encode(id)
+			v, err := enc.Decode(strs[i])
+			if err != nil || v != id {
+				t.Log(strs[i], v, err)
+				t.Fail()
+			}
+		}
+		if !sort.StringsAreSorted(strs) {
+			t.Log(strs)
+			t.Fail()
+		}
+		if _, err := enc.Decode(""); err != ErrInvalidEncoding {
+			t.Fail()
+		}
+		if _, err := enc.Decode(enc.Encode(1) + "0"); err != ErrInvalidEncoding {
+			t.Fail()
+		}
+	}
+	if v, err := Base32Crockford.Decode("1o"); err != nil || v != 32 {
+		t.Log(v, err)
+		t.Fail()
+	}
+	if v, err := Base32Crockford.Decode("il"); err != nil || v != 33 {
+		t.Log(v, err)
+		t.Fail()
+	}
+	if _, err := Base32Crockford.Decode("U"); err != ErrInvalidEncoding {
+		t.Fail()
+	}
+	if _, err := Base58.Decode("0"); err != ErrInvalidEncoding {
+		t.Fail()
+	}
+	if _, err := Base62.Decode("zzzzzzzzzzz"); err != ErrInvalidEncoding {
+		t.Fail()
+	}
+}
+
+func TestIDMarshal(t *testing.T) {
+	v := struct {
+		ID ID `json:"id"`
+	}{ID: math.MaxInt64}
+	b, err := json.Marshal(v)
+	if err != nil || string(b) != `{"id":"9223372036854775807"}` {
+		t.Log(string(b), err)
+		t.Fail()
+	}
+	v.ID = 0
+	if err := json.Unmarshal(b, &v); err != nil || v.ID != math.MaxInt64 {
+		t.Log(v, err)
+		t.Fail()
+	}
+	if err := json.Unmarshal([]byte(`{"id":123}`), &v); err != nil || v.ID != 123 {
+		t.Log(v, err)
+		t.Fail()
+	}
+	if err := json.Unmarshal([]byte(`{"id":"abc"}`), &v); err == nil {
+		t.Fail()
+	}
+	m := map[ID]int{1: 1}
+	if b, err := json.Marshal(m); err != nil || string(b) != `{"1":1}` {
+		t.Log(string(b), err)
+		t.Fail()
+	}
+	if ID(32).Base32() != "0000000000010" {
+		t.Log(ID(32).Base32())
+		t.Fail()
+	}
+}
@@ -0,0 +1,107 @@
+package snowflake
+
+import (
+	"database/sql/driver"
+	"errors"
+	"time"
+)
+
+const (
+	ksuidSize        = 20
+	ksuidStringSize  = 27
+	ksuidPayloadBits = 128
+	// 2014-05-13 16:53:20 UTC
+	KSUIDEpoch = 1400000000
+)
+
+var ErrInvalidKSUID = errors.New("snowflake invalid ksuid")
+
+// 32 bits seconds since KSUIDEpoch and 128 bits random payload, encoded as 27 chars base62
+type KSUID [ksuidSize]byte
+
+func ParseKSUID(s string) (KSUID, error) {
+	var k KSUID
+	b, err := Base62.decodeBytes(s, ksuidStringSize, ksuidSize)
+	if err != nil {
+		return k, ErrInvalidKSUID
+	}
+	copy(k[:], b)
+	return k, nil
+}
+
+func (k KSUID) String() string {
+	return Base62.encodeBytes(k[:], ksuidStringSize)
+}
+
+func (k KSUID) Bytes() []byte {
+	return append([]byte(nil), k[:]...)
+}
+
+func (k KSUID) Time() time.Time {
+	ts := int64(k[0])<<24 | int64(k[1])<<16 | int64(k[2])<<8 | int64(k[3])
+	return time.Unix(ts+KSUIDEpoch, 0)
+}
+
+func (k KSUID) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *KSUID) UnmarshalText(b []byte) error {
+	v, err := ParseKSUID(string(b))
+	if err != nil {
+		return err
+	}
+	*k = v
+	return nil
+}
+
+// accept string or 20 bytes binary
+func (k *KSUID) Scan(src interface{}) error {
+	b, err := scanBytes(src, ksuidSize, func(s string) ([]byte, error) {
+		v, err := ParseKSUID(s)
+		return v[:], err
+	})
+	if err != nil {
+		return err
+	}
+	copy(k[:], b)
+	return nil
+}
+
+func (k KSUID) Value() (driver.Value, error) {
+	return k.String(), nil
+}
+
+// ksuid timestamp is in seconds, payload is monotonic within one second
+type KSUIDGenerator struct {
+	m   *monotonic
+	now func() time.Time
+}
+
+func NewKSUIDGenerator() *KSUIDGenerator {
+	return &KSUIDGenerator{
+		m:   newMonotonic(ksuidPayloadBits),
+		now: time.Now,
+	}
+}
+
+func (g *KSUIDGenerator) Next() (KSUID, error) {
+	var k KSUID
+	ts, rnd, err := g.m.next(g.now().Unix() - KSUIDEpoch)
+	if err != nil {
+		return k, err
+	}
+	if ts < 0 || ts > 1<<32-1 {
+		return k, ErrTimestampOverflow
+	}
+	for i := 3; i >= 0; i-- {
+		k[i] = byte(ts)
+		ts >>= 8
+	}
+	copy(k[4:], rnd)
+	return k, nil
+}
+
+func (g *KSUIDGenerator) NextSortable() (Sortable, error) {
+	return g.Next()
+}
@@ -0,0 +1,47 @@
+package snowflake
+
+import (
+	"errors"
+)
+
+const (
+	MaxMachine    = 0x1F
+	MaxDatacenter = 0x1F
+	// worker id combines datacenter and machine, datacenter is the high 5 bits
+	MaxWorkerID = MaxDatacenter<<5 | MaxMachine
+)
+
+var (
+	ErrInvalidWorkerID   = errors.New("snowflake worker id out of range")
+	ErrWorkerIDExhausted = errors.New("snowflake worker id exhausted")
+	ErrLeaseLost         = errors.New("snowflake worker id lease lost")
+)
+
+// worker id leased from allocator, such as zk.WorkerLease or FileLease.
+// id must not be used after lost channel closed, since it may be leased by others
+type Lease interface {
+	ID() int64
+	Lost() <-chan struct{}
+}
+
+// lease which may be paused without being lost, such as zk.WorkerLease while session disconnected.
+// Active returns a channel closed while lease is usable, id must not be used until it is closed
+type PausableLease interface {
+	Lease
+	Active() <-chan struct{}
+}
+
+func splitWorkerID(id int64) (machine, datacenter int64) {
+	return id & MaxMachine, id >> 5
+}
+
+// machine and datacenter of opt are overwritten by lease id,
+// generator stops issuing id once lease lost, and waits while PausableLease is paused
+func NewIDGeneratorWithLease(opt IDGeneratorOption, lease Lease) (*IDGenerator, error) {
+	id := lease.ID()
+	if id < 0 || id > MaxWorkerID {
+		return nil, ErrInvalidWorkerID
+	}
+	opt.Machine, opt.Datacenter = splitWorkerID(id)
+	return newIDGenerator(opt, lease)
+}
@@ -0,0 +1,66 @@
+//go:build !windows
+// +build !windows
+
+package snowflake
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lease worker id by flock on file "worker-<id>.lock" under dir, only for processes on one host,
+// such as tests. lock is released by os once process exits, so lease is never lost
+type FileWorkerIDAllocator struct {
+	dir   string
+	maxID int64
+}
+
+func NewFileWorkerIDAllocator(dir string, maxID int64) *FileWorkerIDAllocator {
+	return &FileWorkerIDAllocator{
+		dir:   dir,
+		maxID: maxID,
+	}
+}
+
+// return the lowest id not locked by others
+func (a *FileWorkerIDAllocator) Allocate() (*FileLease, error) {
+	if err := os.MkdirAll(a.dir, 0755); err != nil {
+		return nil, err
+	}
+	for id := int64(0); id <= a.maxID; id++ {
+		name := filepath.Join(a.dir, fmt.Sprintf("worker-%d.lock", id))
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return &FileLease{id: id, f: f}, nil
+		}
+		f.Close()
+		if err != syscall.EWOULDBLOCK {
+			return nil, err
+		}
+	}
+	return nil, ErrWorkerIDExhausted
+}
+
+type FileLease struct {
+	id int64
+	f  *os.File
+}
+
+func (l *FileLease) ID() int64 {
+	return l.id
+}
+
+// never closed
+func (l *FileLease) Lost() <-chan struct{} {
+	return nil
+}
+
+func (l *FileLease) Release() error {
+	return l.f.Close()
+}
@@ -0,0 +1,153 @@
+package snowflake
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testLease struct {
+	id   int64
+	lost chan struct{}
+}
+
+func (l *testLease) ID() int64 {
+	return l.id
+}
+
+func (l *testLease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+func TestIDGeneratorWithLease(t *testing.T) {
+	if _, err := NewIDGeneratorWithLease(IDGeneratorOption{}, &testLease{id: MaxWorkerID + 1}); err != ErrInvalidWorkerID {
+		t.Fail()
+	}
+	lease := &testLease{id: 33, lost: make(chan struct{})}
+	g, err := NewIDGeneratorWithLease(IDGeneratorOption{BufferSize: 4}, lease)
+	if err != nil {
+		t.FailNow()
+	}
+	defer g.Close()
+	id, err := g.Next()
+	if err != nil || (id>>12)&MaxWorkerID != 33 {
+		t.Log(id, err)
+		t.Fail()
+	}
+	close(lease.lost)
+	for i := 0; i < 8; i++ {
+		if _, err := g.Next(); err != ErrLeaseLost {
+			t.Log(err)
+			t.Fail()
+		}
+	}
+	defer func() {
+		if recover() != ErrLeaseLost {
+			t.Fail()
+		}
+	}()
+	g.NextID()
+	t.Fail()
+}
+
+type testPausableLease struct {
+	testLease
+	mutex  *sync.Mutex
+	active chan struct{}
+}
+
+func (l *testPausableLease) Active() <-chan struct{} {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.active
+}
+
+func (l *testPausableLease) setActive(active bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if active {
+		close(l.active)
+	} else {
+		l.active = make(chan struct{})
+	}
+}
+
+func TestIDGeneratorWithPausableLease(t *testing.T) {
+	lease := &testPausableLease{
+		testLease: testLease{id: 1, lost: make(chan struct{})},
+		mutex:     &sync.Mutex{},
+		active:    make(chan struct{}),
+	}
+	g, err := NewIDGeneratorWithLease(IDGeneratorOption{BufferSize: 4}, lease)
+	if err != nil {
+		t.FailNow()
+	}
+	defer g.Close()
+
+	// paused from start, no id issued
+	time.Sleep(time.Millisecond * 20)
+	if len(g.idCh) != 0 {
+		t.Fail()
+	}
+	ret := make(chan error, 1)
+	go func() {
+		_, err := g.Next()
+		ret <- err
+	}()
+	select {
+	case <-ret:
+		t.Log("Next not blocked while paused")
+		t.Fail()
+	case <-time.After(time.Millisecond * 20):
+	}
+	lease.setActive(true)
+	if err := <-ret; err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	lease.setActive(false)
+	go func() {
+		_, err := g.Next()
+		ret <- err
+	}()
+	time.Sleep(time.Millisecond * 20)
+	close(lease.lost)
+	if err := <-ret; err != ErrLeaseLost {
+		t.Log(err)
+		t.Fail()
+	}
+}
+
+func TestFileWorkerIDAllocator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snowflake")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	a := NewFileWorkerIDAllocator(dir, 1)
+	l0, err := a.Allocate()
+	if err != nil || l0.ID() != 0 {
+		t.Log(err)
+		t.FailNow()
+	}
+	l1, err := a.Allocate()
+	if err != nil || l1.ID() != 1 {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer l1.Release()
+	if _, err := a.Allocate(); err != ErrWorkerIDExhausted {
+		t.Log(err)
+		t.Fail()
+	}
+	l0.Release()
+	l, err := a.Allocate()
+	if err != nil || l.ID() != 0 {
+		t.Log(err)
+		t.Fail()
+	}
+	l.Release()
+}
@@ -1,51 +1,205 @@
 package snowflake
 
 import (
+	"errors"
+	"sync"
 	"time"
 )
 
+// id is composed of timestamp, datacenter, machine and sequence from high bits to low bits,
+// timestamp is milliseconds since epoch.
+
+var (
+	ErrInvalidLayout     = errors.New("snowflake invalid bit layout")
+	ErrClockRollback     = errors.New("snowflake clock moved backwards")
+	ErrTimestampOverflow = errors.New("snowflake timestamp overflow")
+	ErrInvalidCount      = errors.New("snowflake invalid id count")
+)
+
+// bit widths of id fields, total bits should not exceed 63
+type BitLayout struct {
+	Timestamp  uint `json:"timestamp"`
+	Datacenter uint `json:"datacenter"`
+	Machine    uint `json:"machine"`
+	Sequence   uint `json:"sequence"`
+}
+
+// 41 bits timestamp lasts about 69 years since epoch
+var DefaultLayout = BitLayout{Timestamp: 41, Datacenter: 5, Machine: 5, Sequence: 12}
+
+func (l BitLayout) Validate() error {
+	if l.Timestamp == 0 || l.Sequence == 0 || l.Timestamp+l.Datacenter+l.Machine+l.Sequence > 63 {
+		return ErrInvalidLayout
+	}
+	return nil
+}
+
+func (l BitLayout) machineShift() uint {
+	return l.Sequence
+}
+
+func (l BitLayout) datacenterShift() uint {
+	return l.Sequence + l.Machine
+}
+
+func (l BitLayout) timestampShift() uint {
+	return l.Sequence + l.Machine + l.Datacenter
+}
+
+func mask(bits uint) int64 {
+	return 1<<bits - 1
+}
+
+// how to handle clock moving backwards
+type ClockPolicy int
+
+const (
+	// sleep until clock catches up last timestamp, fail if rollback exceeds MaxWait
+	ClockWait ClockPolicy = iota
+	// fail at once
+	ClockError
+	// keep using last timestamp and move it forward on sequence overflow,
+	// timestamp of id may be ahead of wall clock until clock catches up
+	ClockLogical
+)
+
+const DefaultMaxClockWait = time.Second
+
+type WorkerOption struct {
+	Machine     int64
+	Datacenter  int64
+	Epoch       int64 // milliseconds
+	Layout      BitLayout
+	ClockPolicy ClockPolicy
+	MaxWait     time.Duration // used by ClockWait, default DefaultMaxClockWait
+}
+
+// concurrency safe id worker
 type IdWorker struct {
 	machine       int64
 	datacenter    int64
 	epoch         int64
+	layout        BitLayout
+	policy        ClockPolicy
+	maxWait       time.Duration
+	now           func() int64
+	mutex         *sync.Mutex
 	sequence      int64
-	lasttimestamp int64
+	lasttimestamp int64 // milliseconds since unix epoch, not worker epoch
 }
 
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// worker with default layout and ClockWait policy, machine and datacenter are masked to 5 bits
 func NewIdWorker(machine, datacenter, epoch int64) *IdWorker {
-	return &IdWorker{machine & 0x1F, datacenter & 0x1F, epoch, 0, -1}
+	w, _ := NewIdWorkerWithOption(WorkerOption{
+		Machine:    machine & mask(DefaultLayout.Machine),
+		Datacenter: datacenter & mask(DefaultLayout.Datacenter),
+		Epoch:      epoch,
+	})
+	return w
 }
 
-// caution: this function is not concurrency safety.
-func (this *IdWorker) Generate() (value int64) {
-	timeGen := func() int64 {
-		return time.Now().UnixNano() / int64(time.Millisecond)
+// zero layout means DefaultLayout, return ErrInvalidWorkerID if machine or datacenter exceeds layout
+func (opt WorkerOption) Validate() error {
+	layout := opt.Layout
+	if layout == (BitLayout{}) {
+		layout = DefaultLayout
+	}
+	if err := layout.Validate(); err != nil {
+		return err
 	}
+	if opt.Machine < 0 || opt.Machine > mask(layout.Machine) ||
+		opt.Datacenter < 0 || opt.Datacenter > mask(layout.Datacenter) {
+		return ErrInvalidWorkerID
+	}
+	return nil
+}
+
+// option is checked by Validate
+func NewIdWorkerWithOption(opt WorkerOption) (*IdWorker, error) {
+	if err := opt.Validate(); err != nil {
+		return nil, err
+	}
+	if opt.Layout == (BitLayout{}) {
+		opt.Layout = DefaultLayout
+	}
+	if opt.MaxWait <= 0 {
+		opt.MaxWait = DefaultMaxClockWait
+	}
+	return &IdWorker{
+		machine:       opt.Machine,
+		datacenter:    opt.Datacenter,
+		epoch:         opt.Epoch,
+		layout:        opt.Layout,
+		policy:        opt.ClockPolicy,
+		maxWait:       opt.MaxWait,
+		now:           nowMillis,
+		mutex:         &sync.Mutex{},
+		lasttimestamp: -1,
+	}, nil
+}
+
+func (w *IdWorker) Layout() BitLayout {
+	return w.layout
+}
+
+// return 0 on error.
+//
+// Deprecated: use Next, which reports clock rollback and timestamp overflow.
+func (w *IdWorker) Generate() (value int64) {
+	value, _ = w.Next()
+	return
+}
+
+func (w *IdWorker) Next() (int64, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
 
-	t := timeGen()
-	if t != this.lasttimestamp {
-		this.sequence = 0
-		goto Generate
+	t := w.now()
+	if t < w.lasttimestamp {
+		switch w.policy {
+		case ClockError:
+			return 0, ErrClockRollback
+		case ClockLogical:
+			t = w.lasttimestamp
+		default:
+			if time.Duration(w.lasttimestamp-t)*time.Millisecond > w.maxWait {
+				return 0, ErrClockRollback
+			}
+			for t < w.lasttimestamp {
+				time.Sleep(time.Duration(w.lasttimestamp-t) * time.Millisecond)
+				t = w.now()
+			}
+		}
 	}
 
-	this.sequence = (this.sequence + 1) & 0xFFF
-	if this.sequence == 0 {
-		for {
-			t = timeGen()
-			if t > this.lasttimestamp {
-				break
+	if t == w.lasttimestamp {
+		w.sequence = (w.sequence + 1) & mask(w.layout.Sequence)
+		if w.sequence == 0 {
+			if w.policy == ClockLogical && w.now() <= w.lasttimestamp {
+				// borrow next millisecond
+				t = w.lasttimestamp + 1
+			} else {
+				for t <= w.lasttimestamp {
+					t = w.now()
+				}
 			}
 		}
+	} else {
+		w.sequence = 0
 	}
-Generate:
-	this.lasttimestamp = t - this.epoch
-	// 时间左移 12+5+5
-	value = this.lasttimestamp << 22
-	// 数据中心ID左移17位
-	value |= this.datacenter << 17
-	// 机器码ID左移12位
-	value |= this.machine << 12
-	// 最后12位
-	value |= this.sequence
-	return
+
+	elapsed := t - w.epoch
+	if elapsed < 0 || elapsed > mask(w.layout.Timestamp) {
+		return 0, ErrTimestampOverflow
+	}
+	w.lasttimestamp = t
+	value := elapsed << w.layout.timestampShift()
+	value |= w.datacenter << w.layout.datacenterShift()
+	value |= w.machine << w.layout.machineShift()
+	value |= w.sequence
+	return value, nil
 }
@@ -2,7 +2,9 @@ package snowflake
 
 import (
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestIDGenerator_NextID(t *testing.T) {
@@ -28,6 +30,124 @@ func TestIDGenerator_NextID(t *testing.T) {
 	wg.Wait()
 }
 
+func TestIDGenerator_Invalid(t *testing.T) {
+	opt := IDGeneratorOption{
+		Machine: MaxMachine + 1,
+	}
+	if _, err := NewIDGeneratorE(opt); err != ErrInvalidWorkerID {
+		t.Log(err)
+		t.Fail()
+	}
+	defer func() {
+		if recover() != ErrInvalidWorkerID {
+			t.Fail()
+		}
+	}()
+	NewIDGenerator(opt)
+	t.Fail()
+}
+
+func TestIDGenerator_Option(t *testing.T) {
+	layout := BitLayout{Timestamp: 41, Datacenter: 2, Machine: 3, Sequence: 10}
+	_, err := NewIDGeneratorE(IDGeneratorOption{
+		Machine: 8,
+		Layout:  layout,
+	})
+	if err != ErrInvalidWorkerID {
+		t.Log(err)
+		t.Fail()
+	}
+
+	g := NewIDGenerator(IDGeneratorOption{
+		Machine:    5,
+		Datacenter: 3,
+		Layout:     layout,
+	})
+	defer g.Close()
+	id, err := g.Next()
+	if err != nil || (id>>10)&0x7 != 5 || (id>>13)&0x3 != 3 {
+		t.Log(id, err)
+		t.Fail()
+	}
+}
+
+func TestIDGenerator_Overflow(t *testing.T) {
+	// epoch in the future never recovers
+	g := NewIDGenerator(IDGeneratorOption{
+		BufferSize: 1,
+		Epoch:      nowMillis() + int64(time.Hour/time.Millisecond),
+	})
+	defer g.Close()
+
+	if _, err := g.Next(); err != ErrTimestampOverflow {
+		t.Log(err)
+		t.Fail()
+	}
+	defer func() {
+		if recover() != ErrTimestampOverflow {
+			t.Fail()
+		}
+	}()
+	g.NextID()
+	t.Fail()
+}
+
+func newTestGenerator(opt IDGeneratorOption, clock *testClock) *IDGenerator {
+	w, _ := NewIdWorkerWithOption(opt.workerOption())
+	w.now = clock.now
+	return startGenerator(opt, nil, w)
+}
+
+func TestIDGenerator_ClockPolicy(t *testing.T) {
+	// buffered ids are returned before failure
+	clock := &testClock{t: 100}
+	g := newTestGenerator(IDGeneratorOption{BufferSize: 1, ClockPolicy: ClockError}, clock)
+	defer g.Close()
+	for len(g.idCh) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.set(90)
+	// one id in buffer and at most one pending send
+	ids := 0
+	for {
+		id, err := g.Next()
+		if err != nil {
+			if err != ErrClockRollback || ids == 0 || ids > 2 {
+				t.Log(ids, err)
+				t.Fail()
+			}
+			break
+		}
+		if id>>22 != 100 {
+			t.Log(id)
+			t.Fail()
+		}
+		ids++
+	}
+
+	// ClockWait retries once rollback exceeds MaxWait
+	clock = &testClock{t: 100}
+	g2 := newTestGenerator(IDGeneratorOption{BufferSize: 1, MaxWait: time.Millisecond}, clock)
+	defer g2.Close()
+	if id, err := g2.Next(); err != nil || id>>22 != 100 {
+		t.Log(id, err)
+		t.Fail()
+	}
+	clock.set(90)
+	// drop id generated before rollback
+	for len(g2.idCh) != 0 {
+		g2.Next()
+	}
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		clock.set(101)
+	}()
+	if id, err := g2.Next(); err != nil || id>>22 < 100 {
+		t.Log(id, err)
+		t.Fail()
+	}
+}
+
 func BenchmarkIDGenerator_NextID(b *testing.B) {
 	g := NewIDGenerator(IDGeneratorOption{
 		BufferSize: 16,
@@ -41,3 +161,122 @@ func BenchmarkIDGenerator_NextID(b *testing.B) {
 		}
 	})
 }
+
+type testClock struct {
+	t int64
+}
+
+func (c *testClock) now() int64 {
+	return atomic.LoadInt64(&c.t)
+}
+
+func (c *testClock) set(t int64) {
+	atomic.StoreInt64(&c.t, t)
+}
+
+func TestIdWorker_Next(t *testing.T) {
+	w := NewIdWorker(1, 2, 1500000000000)
+	ids := make(map[int64]bool)
+	for i := 0; i < 10000; i++ {
+		id := w.Generate()
+		if ids[id] {
+			t.Log("duplicate id", id)
+			t.FailNow()
+		}
+		ids[id] = true
+	}
+	if id := w.Generate(); (id>>12)&0x1F != 1 || (id>>17)&0x1F != 2 {
+		t.Log(id)
+		t.Fail()
+	}
+
+	if _, err := NewIdWorkerWithOption(WorkerOption{Machine: 32}); err != ErrInvalidWorkerID {
+		t.Fail()
+	}
+	if _, err := NewIdWorkerWithOption(WorkerOption{Layout: BitLayout{41, 10, 10, 12}}); err != ErrInvalidLayout {
+		t.Fail()
+	}
+	w, err := NewIdWorkerWithOption(WorkerOption{
+		Machine: 5,
+		Layout:  BitLayout{Timestamp: 40, Machine: 3, Sequence: 2},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	clock := &testClock{t: 100}
+	w.now = clock.now
+	for i := int64(0); i < 4; i++ {
+		if id, _ := w.Next(); id != 100<<5|5<<2|i {
+			t.Log(i, id)
+			t.Fail()
+		}
+	}
+}
+
+func TestIdWorker_ClockPolicy(t *testing.T) {
+	clock := &testClock{t: 100}
+	w, _ := NewIdWorkerWithOption(WorkerOption{ClockPolicy: ClockError})
+	w.now = clock.now
+	w.Next()
+	clock.set(99)
+	if _, err := w.Next(); err != ErrClockRollback {
+		t.Fail()
+	}
+
+	w, _ = NewIdWorkerWithOption(WorkerOption{ClockPolicy: ClockWait, MaxWait: time.Millisecond * 10})
+	w.now = clock.now
+	clock.set(100)
+	w.Next()
+	clock.set(50)
+	if _, err := w.Next(); err != ErrClockRollback {
+		t.Fail()
+	}
+	w.now = nowMillis
+	w.lasttimestamp = nowMillis() + 5
+	last := w.lasttimestamp
+	if id, err := w.Next(); err != nil || id>>22 < last {
+		t.Log(id, err)
+		t.Fail()
+	}
+
+	w, _ = NewIdWorkerWithOption(WorkerOption{
+		ClockPolicy: ClockLogical,
+		Layout:      BitLayout{Timestamp: 41, Sequence: 1},
+	})
+	w.now = clock.now
+	clock.set(100)
+	w.Next()
+	clock.set(90)
+	expect := []int64{100<<1 | 1, 101 << 1, 101<<1 | 1, 102 << 1}
+	for _, e := range expect {
+		if id, err := w.Next(); err != nil || id != e {
+			t.Log(id, e, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestIdWorker_Concurrent(t *testing.T) {
+	w := NewIdWorker(0, 0, 0)
+	n := 8
+	results := make(chan []int64, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			ids := make([]int64, 0, 1000)
+			for j := 0; j < 1000; j++ {
+				ids = append(ids, w.Generate())
+			}
+			results <- ids
+		}()
+	}
+	seen := make(map[int64]bool)
+	for i := 0; i < n; i++ {
+		for _, id := range <-results {
+			if seen[id] {
+				t.Log("duplicate id", id)
+				t.FailNow()
+			}
+			seen[id] = true
+		}
+	}
+}
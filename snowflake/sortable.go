@@ -0,0 +1,175 @@
+package snowflake
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// max unix milliseconds of ULID and UUIDv7
+const maxMillis48 = 1<<48 - 1
+
+var (
+	ErrMonotonicOverflow = errors.New("snowflake monotonic random overflow")
+	ErrInvalidScan       = errors.New("snowflake unsupported scan type")
+)
+
+// id ordered by generation time, bytes of later id from one generator sort after earlier one,
+// so do strings of ULID, UUIDv7 and KSUID
+type Sortable interface {
+	String() string
+	Bytes() []byte
+}
+
+// common interface of snowflake, ULID, UUIDv7 and KSUID generators
+type Generator interface {
+	NextSortable() (Sortable, error)
+}
+
+var (
+	_ Generator = (*IDGenerator)(nil)
+	_ Generator = (*AtomicIDGenerator)(nil)
+	_ Generator = (*IdWorker)(nil)
+	_ Generator = (*ULIDGenerator)(nil)
+	_ Generator = (*UUIDv7Generator)(nil)
+	_ Generator = (*KSUIDGenerator)(nil)
+)
+
+func (id ID) Bytes() []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(id))
+	return b
+}
+
+func (g *IDGenerator) NextSortable() (Sortable, error) {
+	id, err := g.Next()
+	return ID(id), err
+}
+
+func (g *AtomicIDGenerator) NextSortable() (Sortable, error) {
+	id, err := g.Next()
+	return ID(id), err
+}
+
+func (w *IdWorker) NextSortable() (Sortable, error) {
+	id, err := w.Next()
+	return ID(id), err
+}
+
+// random part of ids with same timestamp is increased by one from the first random value,
+// so ids are monotonic in one generator even if clock moves backwards
+type monotonic struct {
+	mutex   *sync.Mutex
+	entropy io.Reader
+	bits    uint // random bits, the top byte is masked
+	last    int64
+	rnd     []byte
+}
+
+func newMonotonic(bits uint) *monotonic {
+	return &monotonic{
+		mutex:   &sync.Mutex{},
+		entropy: rand.Reader,
+		bits:    bits,
+		last:    -1,
+		rnd:     make([]byte, (bits+7)/8),
+	}
+}
+
+func (m *monotonic) topMask() byte {
+	if m.bits%8 == 0 {
+		return 0xFF
+	}
+	return byte(1)<<(m.bits%8) - 1
+}
+
+// return timestamp used and copy of random bytes
+func (m *monotonic) next(ts int64) (int64, []byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if ts > m.last {
+		if _, err := io.ReadFull(m.entropy, m.rnd); err != nil {
+			return 0, nil, err
+		}
+		m.rnd[0] &= m.topMask()
+		m.last = ts
+	} else if !m.increase() {
+		return 0, nil, ErrMonotonicOverflow
+	}
+	rnd := make([]byte, len(m.rnd))
+	copy(rnd, m.rnd)
+	return m.last, rnd, nil
+}
+
+func (m *monotonic) increase() bool {
+	for i := len(m.rnd) - 1; i >= 0; i-- {
+		m.rnd[i]++
+		if m.rnd[i] != 0 {
+			break
+		}
+		if i == 0 {
+			return false
+		}
+	}
+	return m.rnd[0]&^m.topMask() == 0
+}
+
+// encode bytes as big-endian number with fixed width
+func (e *Encoding) encodeBytes(b []byte, width int) string {
+	v := new(big.Int).SetBytes(b)
+	base := big.NewInt(int64(len(e.alphabet)))
+	mod := new(big.Int)
+	buf := make([]byte, width)
+	for i := width - 1; i >= 0; i-- {
+		v.DivMod(v, base, mod)
+		buf[i] = e.alphabet[mod.Int64()]
+	}
+	return string(buf)
+}
+
+func (e *Encoding) decodeBytes(s string, width, size int) ([]byte, error) {
+	if len(s) != width {
+		return nil, ErrInvalidEncoding
+	}
+	v := new(big.Int)
+	base := big.NewInt(int64(len(e.alphabet)))
+	for i := 0; i < len(s); i++ {
+		d := e.decodeMap[s[i]]
+		if d < 0 {
+			return nil, ErrInvalidEncoding
+		}
+		v.Mul(v, base)
+		v.Add(v, big.NewInt(int64(d)))
+	}
+	if v.BitLen() > size*8 {
+		return nil, ErrInvalidEncoding
+	}
+	b := make([]byte, size)
+	raw := v.Bytes()
+	copy(b[size-len(raw):], raw)
+	return b, nil
+}
+
+// scan text or raw bytes of size
+func scanBytes(src interface{}, size int, parse func(string) ([]byte, error)) ([]byte, error) {
+	switch v := src.(type) {
+	case string:
+		return parse(v)
+	case []byte:
+		if len(v) == size {
+			b := make([]byte, size)
+			copy(b, v)
+			return b, nil
+		}
+		return parse(string(v))
+	}
+	return nil, ErrInvalidScan
+}
+
+func millisOf(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
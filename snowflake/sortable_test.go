@@ -0,0 +1,144 @@
+package snowflake
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+type textID interface {
+	Sortable
+	Time() time.Time
+	MarshalText() ([]byte, error)
+	Value() (driver.Value, error)
+}
+
+func TestSortableGenerators(t *testing.T) {
+	cases := []struct {
+		g     Generator
+		scan  func() sql.Scanner
+		parse func(string) (Sortable, error)
+	}{
+		{NewULIDGenerator(), func() sql.Scanner { return &ULID{} },
+			func(s string) (Sortable, error) { return ParseULID(s) }},
+		{NewUUIDv7Generator(), func() sql.Scanner { return &UUIDv7{} },
+			func(s string) (Sortable, error) { return ParseUUIDv7(s) }},
+		{NewKSUIDGenerator(), func() sql.Scanner { return &KSUID{} },
+			func(s string) (Sortable, error) { return ParseKSUID(s) }},
+	}
+	now := time.Now()
+	for _, c := range cases {
+		var last Sortable
+		for i := 0; i < 1000; i++ {
+			id, err := c.g.NextSortable()
+			if err != nil {
+				t.Log(err)
+				t.FailNow()
+			}
+			if last != nil && (id.String() <= last.String() || bytes.Compare(id.Bytes(), last.Bytes()) <= 0) {
+				t.Log(last, id)
+				t.FailNow()
+			}
+			last = id
+		}
+
+		tid := last.(textID)
+		if d := tid.Time().Sub(now); d < -time.Second || d > time.Second {
+			t.Log(tid.Time())
+			t.Fail()
+		}
+		parsed, err := c.parse(last.String())
+		if err != nil || parsed != last {
+			t.Log(last, parsed, err)
+			t.Fail()
+		}
+		if _, err := c.parse(last.String()[1:]); err == nil {
+			t.Fail()
+		}
+
+		b, err := json.Marshal(map[string]interface{}{"id": last})
+		if err != nil || string(b) != `{"id":"`+last.String()+`"}` {
+			t.Log(string(b), err)
+			t.Fail()
+		}
+		v, _ := tid.Value()
+		for _, src := range []interface{}{v, []byte(v.(string)), last.Bytes()} {
+			s := c.scan()
+			if err := s.Scan(src); err != nil || s.(Sortable).String() != last.String() {
+				t.Log(src, err)
+				t.Fail()
+			}
+		}
+		if err := c.scan().Scan(1); err != ErrInvalidScan {
+			t.Fail()
+		}
+	}
+}
+
+func TestSortableFormat(t *testing.T) {
+	u, err := ParseULID("01ARYZ6S41TSV4RRFFQ69G5FAV")
+	if err != nil || u.Time().UnixNano()/1e6 != 1469918176385 {
+		t.Log(u, err)
+		t.Fail()
+	}
+	if _, err := ParseULID("81ARZ3NDEKTSV4RRFFQ69G5FAV"); err != ErrInvalidULID {
+		t.Fail()
+	}
+	if u, err := ParseULID(strings.ToLower("01ARZ3NDEKTSV4RRFFQ69G5FAV")); err != nil || u.String() != "01ARZ3NDEKTSV4RRFFQ69G5FAV" {
+		t.Fail()
+	}
+
+	uuid, err := ParseUUIDv7("017F22E2-79B0-7CC3-98C4-DC0C0C07398F")
+	if err != nil || uuid.String() != "017f22e2-79b0-7cc3-98c4-dc0c0c07398f" || uuid.Time().UnixNano()/1e6 != 0x017F22E279B0 {
+		t.Log(uuid, err)
+		t.Fail()
+	}
+	if _, err := ParseUUIDv7("017f22e279b07cc398c4dc0c0c07398f"); err != nil {
+		t.Fail()
+	}
+	if _, err := ParseUUIDv7("017f22e2-79b0-4cc3-98c4-dc0c0c07398f"); err != ErrInvalidUUID {
+		t.Fail()
+	}
+
+	k, err := ParseKSUID("0ujtsYcgvSTl8PAuAdqWYSMnLOv")
+	if err != nil || k.Time().Unix() != 1507608047 {
+		t.Log(k, err)
+		t.Fail()
+	}
+	if _, err := ParseKSUID("aWgEPTl1tmebfsQzFP4bxwgy80W"); err != ErrInvalidKSUID {
+		t.Fail()
+	}
+}
+
+func TestMonotonic(t *testing.T) {
+	m := newMonotonic(10)
+	m.entropy = bytes.NewReader([]byte{0xFF, 0xFD})
+	_, rnd, _ := m.next(5)
+	if !bytes.Equal(rnd, []byte{0x03, 0xFD}) {
+		t.Log(rnd)
+		t.Fail()
+	}
+	ts, rnd, _ := m.next(4)
+	if ts != 5 || !bytes.Equal(rnd, []byte{0x03, 0xFE}) {
+		t.Log(ts, rnd)
+		t.Fail()
+	}
+	m.next(5)
+	if _, _, err := m.next(5); err != ErrMonotonicOverflow {
+		t.Log(err)
+		t.Fail()
+	}
+
+	g := NewUUIDv7Generator()
+	g.m.entropy = bytes.NewReader(bytes.Repeat([]byte{0xFF}, 10))
+	g.now = func() time.Time { return time.Unix(0, 0x017F22E279B0*int64(time.Millisecond)) }
+	u, _ := g.Next()
+	if u.String() != "017f22e2-79b0-7fff-bfff-ffffffffffff" {
+		t.Log(u)
+		t.Fail()
+	}
+}
@@ -0,0 +1,104 @@
+package snowflake
+
+import (
+	"database/sql/driver"
+	"errors"
+	"time"
+)
+
+const (
+	ulidSize       = 16
+	ulidStringSize = 26
+	ulidRandomBits = 80
+)
+
+var ErrInvalidULID = errors.New("snowflake invalid ulid")
+
+// 48 bits unix milliseconds and 80 bits random, encoded as 26 chars base32 crockford
+type ULID [ulidSize]byte
+
+func ParseULID(s string) (ULID, error) {
+	var u ULID
+	b, err := Base32Crockford.decodeBytes(s, ulidStringSize, ulidSize)
+	if err != nil {
+		return u, ErrInvalidULID
+	}
+	copy(u[:], b)
+	return u, nil
+}
+
+func (u ULID) String() string {
+	return Base32Crockford.encodeBytes(u[:], ulidStringSize)
+}
+
+func (u ULID) Bytes() []byte {
+	return append([]byte(nil), u[:]...)
+}
+
+func (u ULID) Time() time.Time {
+	ms := int64(u[0])<<40 | int64(u[1])<<32 | int64(u[2])<<24 | int64(u[3])<<16 | int64(u[4])<<8 | int64(u[5])
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))
+}
+
+func (u ULID) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+func (u *ULID) UnmarshalText(b []byte) error {
+	v, err := ParseULID(string(b))
+	if err != nil {
+		return err
+	}
+	*u = v
+	return nil
+}
+
+// accept string or 16 bytes binary
+func (u *ULID) Scan(src interface{}) error {
+	b, err := scanBytes(src, ulidSize, func(s string) ([]byte, error) {
+		v, err := ParseULID(s)
+		return v[:], err
+	})
+	if err != nil {
+		return err
+	}
+	copy(u[:], b)
+	return nil
+}
+
+func (u ULID) Value() (driver.Value, error) {
+	return u.String(), nil
+}
+
+type ULIDGenerator struct {
+	m   *monotonic
+	now func() time.Time
+}
+
+func NewULIDGenerator() *ULIDGenerator {
+	return &ULIDGenerator{
+		m:   newMonotonic(ulidRandomBits),
+		now: time.Now,
+	}
+}
+
+func (g *ULIDGenerator) Next() (ULID, error) {
+	var u ULID
+	ms, rnd, err := g.m.next(millisOf(g.now()))
+	if err != nil {
+		return u, err
+	}
+	if ms > maxMillis48 {
+		return u, ErrTimestampOverflow
+	}
+	for i := 5; i >= 0; i-- {
+		u[i] = byte(ms)
+		ms >>= 8
+	}
+	copy(u[6:], rnd)
+	return u, nil
+}
+
+func (g *ULIDGenerator) NextSortable() (Sortable, error) {
+	return g.Next()
+}
@@ -0,0 +1,136 @@
+package snowflake
+
+import (
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	uuidSize         = 16
+	uuidv7RandomBits = 74
+)
+
+var ErrInvalidUUID = errors.New("snowflake invalid uuid")
+
+// UUID version 7 of RFC 9562: 48 bits unix milliseconds, 4 bits version,
+// 12 bits rand_a, 2 bits variant and 62 bits rand_b.
+// rand_a and rand_b are used as one monotonic random field.
+type UUIDv7 [uuidSize]byte
+
+// accept canonical form and 32 hex digits without hyphen
+func ParseUUIDv7(s string) (UUIDv7, error) {
+	var u UUIDv7
+	if len(s) == 36 {
+		if s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+			return u, ErrInvalidUUID
+		}
+		s = strings.Replace(s, "-", "", -1)
+	}
+	if len(s) != 32 {
+		return u, ErrInvalidUUID
+	}
+	if _, err := hex.Decode(u[:], []byte(s)); err != nil {
+		return u, ErrInvalidUUID
+	}
+	if u.Version() != 7 || u[8]&0xC0 != 0x80 {
+		return u, ErrInvalidUUID
+	}
+	return u, nil
+}
+
+func (u UUIDv7) Version() int {
+	return int(u[6] >> 4)
+}
+
+func (u UUIDv7) String() string {
+	buf := make([]byte, 36)
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf)
+}
+
+func (u UUIDv7) Bytes() []byte {
+	return append([]byte(nil), u[:]...)
+}
+
+func (u UUIDv7) Time() time.Time {
+	ms := int64(u[0])<<40 | int64(u[1])<<32 | int64(u[2])<<24 | int64(u[3])<<16 | int64(u[4])<<8 | int64(u[5])
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))
+}
+
+func (u UUIDv7) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+func (u *UUIDv7) UnmarshalText(b []byte) error {
+	v, err := ParseUUIDv7(string(b))
+	if err != nil {
+		return err
+	}
+	*u = v
+	return nil
+}
+
+// accept string or 16 bytes binary
+func (u *UUIDv7) Scan(src interface{}) error {
+	b, err := scanBytes(src, uuidSize, func(s string) ([]byte, error) {
+		v, err := ParseUUIDv7(s)
+		return v[:], err
+	})
+	if err != nil {
+		return err
+	}
+	copy(u[:], b)
+	return nil
+}
+
+func (u UUIDv7) Value() (driver.Value, error) {
+	return u.String(), nil
+}
+
+type UUIDv7Generator struct {
+	m   *monotonic
+	now func() time.Time
+}
+
+func NewUUIDv7Generator() *UUIDv7Generator {
+	return &UUIDv7Generator{
+		m:   newMonotonic(uuidv7RandomBits),
+		now: time.Now,
+	}
+}
+
+func (g *UUIDv7Generator) Next() (UUIDv7, error) {
+	var u UUIDv7
+	ms, rnd, err := g.m.next(millisOf(g.now()))
+	if err != nil {
+		return u, err
+	}
+	if ms > maxMillis48 {
+		return u, ErrTimestampOverflow
+	}
+	for i := 5; i >= 0; i-- {
+		u[i] = byte(ms)
+		ms >>= 8
+	}
+	// rnd is 10 bytes with 74 bits, the top 12 bits go to rand_a and the others to rand_b
+	u[6] = 0x70 | (rnd[0]<<2|rnd[1]>>6)&0x0F
+	u[7] = rnd[1]<<2 | rnd[2]>>6
+	u[8] = 0x80 | rnd[2]&0x3F
+	copy(u[9:], rnd[3:])
+	return u, nil
+}
+
+func (g *UUIDv7Generator) NextSortable() (Sortable, error) {
+	return g.Next()
+}
@@ -0,0 +1,155 @@
+package zk
+
+import (
+	"errors"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+var (
+	ErrInvalidACL  = errors.New("zk invalid acl")
+	ErrInvalidAuth = errors.New("zk invalid auth")
+)
+
+// acl policies of created nodes
+var (
+	// anyone could do anything, default acl
+	ACLOpenUnsafe = zk.WorldACL(zk.PermAll)
+	// only authenticated creator could access, client should add auth before create
+	ACLCreatorAll = zk.AuthACL(zk.PermAll)
+	// authenticated creator could do anything, others could only read
+	ACLCreatorAllWorldRead = append(zk.AuthACL(zk.PermAll), zk.WorldACL(zk.PermRead)...)
+)
+
+// auth credential, scheme could be any scheme supported by zookeeper server, such as digest
+type AuthInfo struct {
+	Scheme string
+	Auth   []byte
+}
+
+func DigestAuth(user, password string) AuthInfo {
+	return AuthInfo{Scheme: "digest", Auth: []byte(user + ":" + password)}
+}
+
+// auth credentials and acl of nodes created by client
+type ACLOption struct {
+	Auths []AuthInfo
+	ACL   []zk.ACL // default ACLOpenUnsafe
+}
+
+// add auth credential which is sent on connect, and replayed by go-zookeeper on every
+// reconnection and session rebuild. if client is connected, auth is sent at once
+func (cli *ZKClient) AddAuth(scheme string, auth []byte) error {
+	if scheme == "" {
+		return ErrInvalidAuth
+	}
+	// Connect checks auths again before setting conn, so auth added meanwhile is not missed
+	cli.optLock.Lock()
+	cli.auths = append(cli.auths, AuthInfo{Scheme: scheme, Auth: auth})
+	cli.optLock.Unlock()
+
+	cli.connLock.RLock()
+	conn := cli.conn
+	cli.connLock.RUnlock()
+	if conn != nil {
+		return conn.AddAuth(scheme, auth)
+	}
+	return nil
+}
+
+func (cli *ZKClient) AddDigestAuth(user, password string) error {
+	a := DigestAuth(user, password)
+	return cli.AddAuth(a.Scheme, a.Auth)
+}
+
+// set acl of nodes created later
+func (cli *ZKClient) SetACL(acl []zk.ACL) {
+	cli.optLock.Lock()
+	cli.acl = acl
+	cli.optLock.Unlock()
+}
+
+func (opt ACLOption) Validate() error {
+	for _, a := range opt.Auths {
+		if a.Scheme == "" {
+			return ErrInvalidAuth
+		}
+	}
+	// empty acl is rejected rather than falling back to ACLOpenUnsafe
+	if opt.ACL != nil && len(opt.ACL) == 0 {
+		return ErrInvalidACL
+	}
+	for _, acl := range opt.ACL {
+		if acl.Scheme == "" || acl.Perms == 0 || acl.Perms&^zk.PermAll != 0 {
+			return ErrInvalidACL
+		}
+	}
+	return nil
+}
+
+// nothing is set if opt is invalid
+func (cli *ZKClient) SetACLOption(opt ACLOption) error {
+	if err := opt.Validate(); err != nil {
+		return err
+	}
+	for _, a := range opt.Auths {
+		if err := cli.AddAuth(a.Scheme, a.Auth); err != nil {
+			return err
+		}
+	}
+	if opt.ACL != nil {
+		cli.SetACL(opt.ACL)
+	}
+	return nil
+}
+
+func (cli *ZKClient) nodeACL() []zk.ACL {
+	cli.optLock.RLock()
+	defer cli.optLock.RUnlock()
+	if len(cli.acl) == 0 {
+		return ACLOpenUnsafe
+	}
+	return cli.acl
+}
+
+func (cli *ZKClient) authInfos() []AuthInfo {
+	cli.optLock.RLock()
+	defer cli.optLock.RUnlock()
+	return append([]AuthInfo(nil), cli.auths...)
+}
+
+// create node with acl instead of client acl, parent nodes would be created with client acl
+func (cli *ZKClient) CreateWithACL(npath string, data []byte, mode CreateMode, acl []zk.ACL) (string, error) {
+	return cli.createWithACL(npath, data, mode, acl)
+}
+
+// return acl and acl version of node
+func (cli *ZKClient) GetACL(npath string) ([]zk.ACL, int32, error) {
+	conn := cli.getConn()
+	if conn == nil {
+		return nil, 0, ErrNoZkConnection
+	}
+	fpath, err := cli.fullPath(npath)
+	if err != nil {
+		return nil, 0, err
+	}
+	acl, stat, err := conn.GetACL(fpath)
+	if err != nil {
+		return nil, 0, err
+	}
+	return acl, stat.Aversion, nil
+}
+
+// set node acl, version is acl version, -1 matches any version
+func (cli *ZKClient) SetNodeACL(npath string, acl []zk.ACL, version int32) error {
+	conn := cli.getConn()
+	if conn == nil {
+		return ErrNoZkConnection
+	}
+	fpath, err := cli.fullPath(npath)
+	if err != nil {
+		return err
+	}
+	_, err = conn.SetACL(fpath, acl, version)
+	return err
+}
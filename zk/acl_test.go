@@ -0,0 +1,153 @@
+package zk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestACLOption(t *testing.T) {
+	root := "/acltest"
+	npath := root + "/node"
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	cli.SetACLOption(ACLOption{
+		Auths: []AuthInfo{DigestAuth("user", "pass")},
+		ACL:   ACLCreatorAllWorldRead,
+	})
+	if _, err := cli.CreateWithACL(npath, []byte("data"), ModePersistent, ACLCreatorAll); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer cli.DeleteNode(root)
+	defer cli.DeleteNode(npath)
+
+	acl, _, err := cli.GetACL(root)
+	if err != nil || len(acl) != 2 || acl[0].Scheme != "digest" || acl[1].Perms != zk.PermRead {
+		t.Log(acl, err)
+		t.Fail()
+	}
+	acl, _, err = cli.GetACL(npath)
+	if err != nil || len(acl) != 1 || acl[0].Perms != zk.PermAll {
+		t.Log(acl, err)
+		t.Fail()
+	}
+
+	other := NewZKClient(testServers, time.Second*5, nil)
+	defer other.Close()
+	if _, err := other.GetChildren(root); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+	if _, _, err := other.GetData(npath); err != zk.ErrNoAuth {
+		t.Log(err)
+		t.Fail()
+	}
+	if err := other.AddDigestAuth("user", "pass"); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if data, _, err := other.GetData(npath); err != nil || string(data) != "data" {
+		t.Log(err)
+		t.Fail()
+	}
+}
+
+func TestACLOptionValidate(t *testing.T) {
+	invalid := []ACLOption{
+		{ACL: []zk.ACL{}},
+		{ACL: []zk.ACL{{Perms: zk.PermAll, Scheme: ""}}},
+		{ACL: []zk.ACL{{Perms: 0, Scheme: "world", ID: "anyone"}}},
+		{ACL: []zk.ACL{{Perms: zk.PermAll << 1, Scheme: "world", ID: "anyone"}}},
+		{Auths: []AuthInfo{{Auth: []byte("user:pass")}}},
+	}
+	for _, opt := range invalid {
+		if opt.Validate() == nil {
+			t.Log(opt)
+			t.Fail()
+		}
+	}
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	if cli.SetACLOption(ACLOption{Auths: []AuthInfo{DigestAuth("user", "pass")}, ACL: []zk.ACL{}}) == nil ||
+		len(cli.authInfos()) != 0 {
+		t.Fail()
+	}
+	if (ACLOption{}).Validate() != nil || (ACLOption{ACL: ACLCreatorAllWorldRead}).Validate() != nil {
+		t.Fail()
+	}
+}
+
+func TestAuthReplay(t *testing.T) {
+	if testSrv == nil {
+		t.Skip("session expiry needs in-memory server")
+	}
+	npath := "/acltest-replay"
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	cli.AddDigestAuth("user", "pass")
+	cli.SetACL(ACLCreatorAll)
+	if _, err := cli.CreateWithData(npath, nil, ModePersistent); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer cli.DeleteNode(npath)
+
+	states := cli.WatchSessionState()
+	defer states.Close()
+	testSrv.ExpireAllSessions()
+	for ev := range states.States {
+		if ev.State == StateHasSession {
+			break
+		}
+	}
+	if _, _, err := cli.GetData(npath); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+}
+
+func TestRegisterWithACL(t *testing.T) {
+	spath := "/acltest-service"
+	opt := ACLOption{
+		Auths: []AuthInfo{DigestAuth("service", "secret")},
+		ACL:   ACLCreatorAll,
+	}
+	if _, err := NewZKRegisterWithACL(testServers, time.Second*5, spath, ACLOption{ACL: []zk.ACL{}}); err != ErrInvalidACL {
+		t.Log(err)
+		t.Fail()
+	}
+	if _, err := NewZKMonitorWithACL(testServers, time.Second*5, &testService{}, nil, spath,
+		ACLOption{Auths: []AuthInfo{{}}}); err != ErrInvalidAuth {
+		t.Log(err)
+		t.Fail()
+	}
+
+	r, err := NewZKRegisterWithACL(testServers, time.Second*5, spath, opt)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer r.Close()
+	defer r.cli.DeleteNode(spath)
+	addr := "127.0.0.1:8080"
+	if err := r.Register(addr); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer r.Deregister(addr)
+
+	zkm, err := NewZKMonitorWithACL(testServers, time.Second*5, &testService{}, nil, spath, opt)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	zkm.Run()
+	defer zkm.Close()
+	time.Sleep(time.Millisecond * 500)
+	if conn := zkm.RoundTripGetter().GetConn(); conn == nil {
+		t.Log(zkm.Status())
+		t.Fail()
+	}
+}
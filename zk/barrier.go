@@ -0,0 +1,200 @@
+package zk
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	barrierPrefix    = "node-"
+	barrierReadyNode = "ready"
+)
+
+var (
+	ErrBarrierEntered    = errors.New("zk barrier already entered")
+	ErrBarrierNotEntered = errors.New("zk barrier not entered")
+)
+
+// wait until node deleted or ctx done
+func (cli *ZKClient) waitDelete(ctx context.Context, npath string) (bool, error) {
+	exist, ev, err := cli.existsW(npath)
+	if err != nil || !exist {
+		return false, err
+	}
+	select {
+	case <-ev:
+		return true, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// barrier blocks all waiters while barrier node exists
+type Barrier struct {
+	cli  *ZKClient
+	path string
+}
+
+func NewBarrier(cli *ZKClient, npath string) *Barrier {
+	return &Barrier{
+		cli:  cli,
+		path: JoinPath(npath),
+	}
+}
+
+// create barrier node, ok if already set
+func (b *Barrier) Set() error {
+	_, err := b.cli.CreateWithData(b.path, nil, ModePersistent)
+	if err == zk.ErrNodeExists {
+		return nil
+	}
+	return err
+}
+
+// delete barrier node and release all waiters
+func (b *Barrier) Remove() error {
+	err := b.cli.DeleteNode(b.path)
+	if err == zk.ErrNoNode {
+		return nil
+	}
+	return err
+}
+
+// block until barrier removed or ctx done, return at once if barrier not set
+func (b *Barrier) Wait(ctx context.Context) error {
+	for {
+		changed, err := b.cli.waitDelete(ctx, b.path)
+		if err != nil || !changed {
+			return err
+		}
+	}
+}
+
+// double barrier synchronizes start and end of computation of count participants.
+// every participant has an ephemeral sequential node under barrier dir,
+// Enter blocks until count participants entered, Leave blocks until all participants left.
+// a DoubleBarrier object is one participant and goes through one Enter/Leave cycle per round,
+// Enter returns ErrBarrierEntered until Leave done, so every participant needs its own object.
+type DoubleBarrier struct {
+	cli   *ZKClient
+	dir   string
+	count int
+	mutex *sync.Mutex
+	node  string
+}
+
+func NewDoubleBarrier(cli *ZKClient, dir string, count int) *DoubleBarrier {
+	return &DoubleBarrier{
+		cli:   cli,
+		dir:   JoinPath(dir),
+		count: count,
+		mutex: &sync.Mutex{},
+	}
+}
+
+func (b *DoubleBarrier) Enter(ctx context.Context) error {
+	b.mutex.Lock()
+	entered := b.node != ""
+	b.mutex.Unlock()
+	if entered {
+		return ErrBarrierEntered
+	}
+
+	ready := JoinPath(b.dir, barrierReadyNode)
+	// watch ready node before creating own node, so that creation of ready node is not missed
+	exist, ev, err := b.cli.existsW(ready)
+	if err != nil {
+		return err
+	}
+	node, err := b.cli.CreateWithData(JoinPath(b.dir, barrierPrefix), nil, ModeEphemeralSequential)
+	if err != nil {
+		return err
+	}
+	b.mutex.Lock()
+	b.node = node
+	b.mutex.Unlock()
+	if exist {
+		return nil
+	}
+
+	children, err := b.cli.GetChildren(b.dir)
+	if err != nil {
+		b.abort(node)
+		return err
+	}
+	if len(sortBySequence(children)) >= b.count {
+		_, err = b.cli.CreateWithData(ready, nil, ModePersistent)
+		if err != nil && err != zk.ErrNodeExists {
+			b.abort(node)
+			return err
+		}
+		return nil
+	}
+	select {
+	case <-ev:
+		return nil
+	case <-ctx.Done():
+		b.abort(node)
+		return ctx.Err()
+	}
+}
+
+func (b *DoubleBarrier) abort(node string) {
+	b.cli.DeleteNode(node)
+	b.mutex.Lock()
+	b.node = ""
+	b.mutex.Unlock()
+}
+
+// the lowest node leaves at last and removes ready node, others delete own node at once
+// and wait for the lowest node
+func (b *DoubleBarrier) Leave(ctx context.Context) error {
+	b.mutex.Lock()
+	node := b.node
+	b.mutex.Unlock()
+	if node == "" {
+		return ErrBarrierNotEntered
+	}
+	_, name := SplitPath(node)
+	for {
+		children, err := b.cli.GetChildren(b.dir)
+		if err != nil {
+			return err
+		}
+		nodes := sortBySequence(children)
+		if len(nodes) == 0 {
+			break
+		}
+		if len(nodes) == 1 && nodes[0] == name {
+			err = b.cli.DeleteNode(JoinPath(b.dir, barrierReadyNode))
+			if err != nil && err != zk.ErrNoNode {
+				return err
+			}
+			err = b.cli.DeleteNode(node)
+			if err != nil && err != zk.ErrNoNode {
+				return err
+			}
+			break
+		}
+
+		wait := nodes[0]
+		if wait == name {
+			wait = nodes[len(nodes)-1]
+		} else {
+			err = b.cli.DeleteNode(node)
+			if err != nil && err != zk.ErrNoNode {
+				return err
+			}
+		}
+		if _, err := b.cli.waitDelete(ctx, JoinPath(b.dir, wait)); err != nil {
+			return err
+		}
+	}
+	b.mutex.Lock()
+	b.node = ""
+	b.mutex.Unlock()
+	return nil
+}
@@ -0,0 +1,99 @@
+package zk
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBarrier(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	b := NewBarrier(cli, "/test/barrier")
+	if err := b.Wait(context.Background()); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := b.Set(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer b.Remove()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if err := b.Wait(ctx); err != context.DeadlineExceeded {
+		t.Log(err)
+		t.Fail()
+	}
+	done := make(chan error)
+	go func() {
+		done <- b.Wait(context.Background())
+	}()
+	time.Sleep(time.Millisecond * 100)
+	b.Remove()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+		}
+	case <-time.After(time.Second * 3):
+		t.Log("wait not released")
+		t.Fail()
+	}
+}
+
+func TestDoubleBarrier(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	dir := "/test/dbarrier"
+	defer cli.DeleteNode(dir)
+
+	single := NewDoubleBarrier(cli, dir, 2)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if err := single.Enter(ctx); err != context.DeadlineExceeded {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := single.Leave(context.Background()); err != ErrBarrierNotEntered {
+		t.Log(err)
+		t.Fail()
+	}
+
+	count := 3
+	var entered int32
+	wg := &sync.WaitGroup{}
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			time.Sleep(time.Millisecond * 50 * time.Duration(i))
+			b := NewDoubleBarrier(cli, dir, count)
+			if err := b.Enter(context.Background()); err != nil {
+				t.Log(err)
+				t.Fail()
+				return
+			}
+			atomic.AddInt32(&entered, 1)
+			time.Sleep(time.Millisecond * 50 * time.Duration(count-i))
+			if atomic.LoadInt32(&entered) != int32(count) {
+				t.Log("leave before all entered")
+				t.Fail()
+			}
+			if err := b.Leave(context.Background()); err != nil {
+				t.Log(err)
+				t.Fail()
+			}
+		}(i)
+	}
+	wg.Wait()
+	children, err := cli.GetChildren(dir)
+	if err != nil || len(children) != 0 {
+		t.Log(children, err)
+		t.Fail()
+	}
+}
@@ -3,7 +3,6 @@ package zk
 import (
 	"errors"
 	"net"
-	"path"
 	"time"
 
 	log "github.com/alecthomas/log4go"
@@ -15,34 +14,80 @@ import (
 	"github.com/samuel/go-zookeeper/zk"
 )
 
-const zkSpliter = "/"
-
 var (
-	ErrNoZkConnection = errors.New("no zk connection")
+	ErrNoZkConnection  = errors.New("no zk connection")
+	ErrVersionConflict = errors.New("zk node version conflict")
+)
+
+// node create mode used by CreateWithData
+type CreateMode int32
+
+const (
+	ModePersistent           CreateMode = 0
+	ModeEphemeral            CreateMode = zk.FlagEphemeral
+	ModePersistentSequential CreateMode = zk.FlagSequence
+	ModeEphemeralSequential  CreateMode = zk.FlagEphemeral | zk.FlagSequence
 )
 
+// match any node version
+const anyVersion = int32(-1)
+
 type ZKClient struct {
 	Servers        []string
 	SessionTimeout time.Duration
 	Dialer         zk.Dialer
 
+	chroot           string // guarded by optLock
+	chrootErr        error
 	fnLock           *sync.RWMutex
 	fnOnSessionBuild func(*ZKClient)
 	connLock         *sync.RWMutex
 	conn             *zk.Conn
+	state            int32 // SessionState
+	stateLock        *sync.RWMutex
+	stateWatchers    map[*StateWatcher]struct{}
+	optLock          *sync.RWMutex
+	auths            []AuthInfo
+	acl              []zk.ACL
 }
 
+// server address could have chroot suffix like "127.0.0.1:2181/app",
+// then all paths are relative to "/app". servers with different chroot are rejected,
+// all operations return error until SetChroot called.
 func NewZKClient(addrs []string, sessionTimeout time.Duration,
 	dialer func(network, address string, timeout time.Duration) (net.Conn, error)) *ZKClient {
 	if dialer == nil {
 		dialer = net.DialTimeout
 	}
+	servers := make([]string, 0, len(addrs))
+	chroot := ""
+	var chrootErr error
+	for _, addr := range addrs {
+		server, root := splitChroot(addr)
+		if root != "" {
+			if chroot != "" && JoinPath(chroot) != JoinPath(root) {
+				chrootErr = ErrChrootConflict
+			}
+			chroot = root
+		}
+		servers = append(servers, server)
+	}
 	cli := &ZKClient{
-		Servers:        addrs,
+		Servers:        servers,
 		SessionTimeout: sessionTimeout,
 		Dialer:         zk.Dialer(dialer),
 		fnLock:         &sync.RWMutex{},
 		connLock:       &sync.RWMutex{},
+		stateLock:      &sync.RWMutex{},
+		stateWatchers:  make(map[*StateWatcher]struct{}),
+		optLock:        &sync.RWMutex{},
+	}
+	if chroot != "" && chrootErr == nil {
+		chrootErr = cli.SetChroot(chroot)
+	}
+	if chrootErr != nil {
+		log.Error("invalid chroot of servers:%v, error:%v", addrs, chrootErr)
+		cli.chrootErr = chrootErr
 	}
 	return cli
 }
@@ -79,14 +124,34 @@ func (cli *ZKClient) Connect() error {
 	if err != nil {
 		return err
 	}
-	cli.connLock.Lock()
-	cli.conn = conn
-	cli.connLock.Unlock()
+	// auth requests are sent before conn is set, so they are queued before any other request,
+	// session events are buffered meanwhile. auths are counted again under connLock,
+	// auth added after conn set is sent by AddAuth itself
+	sent := 0
+	for {
+		auths := cli.authInfos()
+		for _, a := range auths[sent:] {
+			if err := conn.AddAuth(a.Scheme, a.Auth); err != nil {
+				conn.Close()
+				return err
+			}
+		}
+		sent = len(auths)
+
+		cli.connLock.Lock()
+		if len(cli.authInfos()) == sent {
+			cli.conn = conn
+			cli.connLock.Unlock()
+			break
+		}
+		cli.connLock.Unlock()
+	}
 
 	sched := make(chan struct{})
 	go func() {
 		close(sched)
 		for e := range session {
+			cli.onSessionEvent(e)
 			if e.State == zk.StateDisconnected {
 				log.Error("session disconnected, event:%s", e)
 			} else if e.State == zk.StateHasSession {
@@ -116,56 +181,97 @@ func (cli *ZKClient) SetFuncOnSessionBuild(fn func(*ZKClient)) {
 }
 
 func (cli *ZKClient) CreatePersistNode(npath string) error {
-	npath = strings.TrimRight(npath, zkSpliter)
-	return cli.createNode(npath, int32(0))
+	fpath, err := cli.fullPath(npath)
+	if err != nil {
+		return err
+	}
+	return cli.createNode(fpath, int32(0))
 }
 
 func (cli *ZKClient) CreateEphemeralNode(npath string) error {
-	npath = strings.TrimRight(npath, zkSpliter)
-	d := path.Dir(npath)
-	err := cli.createNode(d, int32(0))
+	fpath, err := cli.fullPath(npath)
 	if err != nil {
 		return err
 	}
+	_, err = cli.createEphemeral(fpath)
+	return err
+}
+
+// create ephemeral node and its parents by server path, return server paths created by this call
+func (cli *ZKClient) createEphemeral(fpath string) ([]string, error) {
+	d, _ := SplitPath(fpath)
+	created, err := cli.createNodes(d, int32(0))
+	if err != nil {
+		return created, err
+	}
 
 	conn := cli.getConn()
 	if conn == nil {
-		return ErrNoZkConnection
+		return created, ErrNoZkConnection
+	}
+	_, err = conn.Create(fpath, []byte(""), int32(zk.FlagEphemeral), cli.nodeACL())
+	if err != nil {
+		return created, err
 	}
-	_, err = conn.Create(npath, []byte(""), int32(zk.FlagEphemeral), zk.WorldACL(zk.PermAll))
+	return append(created, fpath), nil
+}
+
+// create node and its parents by server path
+func (cli *ZKClient) createNode(fpath string, flags int32) error {
+	_, err := cli.createNodes(fpath, flags)
 	return err
 }
 
-func (cli *ZKClient) createNode(npath string, flags int32) error {
+// create node and its parents by server path, return server paths created by this call
+func (cli *ZKClient) createNodes(fpath string, flags int32) ([]string, error) {
 	conn := cli.getConn()
 	if conn == nil {
-		return ErrNoZkConnection
+		return nil, ErrNoZkConnection
 	}
-	level := strings.Split(npath, zkSpliter)
+	level := strings.Split(fpath, pathSeparator)
 	if len(level) == 0 {
-		return nil
+		return nil, nil
 	}
 
-	acl := zk.WorldACL(zk.PermAll)
-	npath = ""
+	acl := cli.nodeACL()
+	npath := ""
+	var created []string
 	for _, lvl := range level {
 		if lvl == "" {
 			continue
 		}
-		npath = npath + zkSpliter + lvl
+		npath = npath + pathSeparator + lvl
 
 		exist, _, err := conn.Exists(npath)
 		if err != nil {
-			return err
+			return created, err
 		}
 		if !exist {
 			_, err = conn.Create(npath, []byte(lvl), flags, acl)
-			if err != nil {
-				return err
+			if err == nil {
+				created = append(created, npath)
+			} else if err != zk.ErrNodeExists {
+				// ErrNodeExists means created by others meanwhile
+				return created, err
 			}
 		}
 	}
-	return nil
+	return created, nil
+}
+
+// delete server paths returned by createNodes in reverse order,
+// nodes which have got children from others meanwhile are kept
+func (cli *ZKClient) deleteNodes(created []string) {
+	conn := cli.getConn()
+	if conn == nil {
+		return
+	}
+	for i := len(created) - 1; i >= 0; i-- {
+		err := conn.Delete(created[i], anyVersion)
+		if err != nil && err != zk.ErrNoNode && err != zk.ErrNotEmpty {
+			log.Error("delete node:%s error:%v", created[i], err)
+		}
+	}
 }
 
 func (cli *ZKClient) NodeExist(npath string) (bool, error) {
@@ -173,8 +279,11 @@ func (cli *ZKClient) NodeExist(npath string) (bool, error) {
 	if conn == nil {
 		return false, ErrNoZkConnection
 	}
-	npath = strings.TrimRight(npath, zkSpliter)
-	ok, _, err := conn.Exists(npath)
+	fpath, err := cli.fullPath(npath)
+	if err != nil {
+		return false, err
+	}
+	ok, _, err := conn.Exists(fpath)
 	return ok, err
 }
 
@@ -183,16 +292,100 @@ func (cli *ZKClient) DeleteNode(npath string) error {
 	if conn == nil {
 		return ErrNoZkConnection
 	}
-	npath = strings.TrimRight(npath, zkSpliter)
-	return conn.Delete(npath, int32(0))
+	fpath, err := cli.fullPath(npath)
+	if err != nil {
+		return err
+	}
+	return conn.Delete(fpath, anyVersion)
+}
+
+// create node with data, parent nodes would be created as persist node
+// return created path, which has sequence suffix in sequential mode
+func (cli *ZKClient) CreateWithData(npath string, data []byte, mode CreateMode) (string, error) {
+	return cli.createWithACL(npath, data, mode, cli.nodeACL())
+}
+
+func (cli *ZKClient) createWithACL(npath string, data []byte, mode CreateMode, acl []zk.ACL) (string, error) {
+	fpath, err := cli.fullPath(npath)
+	if err != nil {
+		return "", err
+	}
+	d, _ := SplitPath(fpath)
+	err = cli.createNode(d, int32(0))
+	if err != nil {
+		return "", err
+	}
+
+	conn := cli.getConn()
+	if conn == nil {
+		return "", ErrNoZkConnection
+	}
+	created, err := conn.Create(fpath, data, int32(mode), acl)
+	if err != nil {
+		return "", err
+	}
+	return cli.clientPath(created), nil
+}
+
+// return node data and data version
+func (cli *ZKClient) GetData(npath string) ([]byte, int32, error) {
+	conn := cli.getConn()
+	if conn == nil {
+		return nil, 0, ErrNoZkConnection
+	}
+	fpath, err := cli.fullPath(npath)
+	if err != nil {
+		return nil, 0, err
+	}
+	data, stat, err := conn.Get(fpath)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, stat.Version, nil
+}
+
+// overwrite node data whatever the version is
+func (cli *ZKClient) SetData(npath string, data []byte) error {
+	_, err := cli.setData(npath, data, anyVersion)
+	return err
+}
+
+// set node data only if node data version equal to version
+// return new version, or ErrVersionConflict if node has been modified
+func (cli *ZKClient) CompareAndSet(npath string, data []byte, version int32) (int32, error) {
+	newVersion, err := cli.setData(npath, data, version)
+	if err == zk.ErrBadVersion {
+		return 0, ErrVersionConflict
+	}
+	return newVersion, err
+}
+
+func (cli *ZKClient) setData(npath string, data []byte, version int32) (int32, error) {
+	conn := cli.getConn()
+	if conn == nil {
+		return 0, ErrNoZkConnection
+	}
+	fpath, err := cli.fullPath(npath)
+	if err != nil {
+		return 0, err
+	}
+	stat, err := conn.Set(fpath, data, version)
+	if err != nil {
+		return 0, err
+	}
+	return stat.Version, nil
 }
 
-func (cli *ZKClient) GetChildren(path string) ([]string, error) {
+func (cli *ZKClient) GetChildren(npath string) ([]string, error) {
 	conn := cli.getConn()
 	if conn == nil {
 		return nil, ErrNoZkConnection
 	}
-	children, _, err := conn.Children(path)
+	fpath, err := cli.fullPath(npath)
+	if err != nil {
+		return nil, err
+	}
+	children, _, err := conn.Children(fpath)
 	if err != nil {
 		return nil, err
 	}
@@ -225,6 +418,11 @@ func (cli *ZKClient) WatchDir(dir string) (w *DirWatcher) {
 		log.Error("watch dir:%s fail, no zk connection", dir)
 		return nil
 	}
+	fpath, err := cli.fullPath(dir)
+	if err != nil {
+		log.Error("watch dir:%s fail, error:%v", dir, err)
+		return nil
+	}
 	w = &DirWatcher{
 		stop:     make(chan struct{}),
 		exited:   make(chan struct{}),
@@ -240,7 +438,7 @@ func (cli *ZKClient) WatchDir(dir string) (w *DirWatcher) {
 				log.Info("stop watch path:%s", dir)
 				return
 			}
-			snapshot, _, ev, err := conn.ChildrenW(dir)
+			snapshot, _, ev, err := conn.ChildrenW(fpath)
 			if err != nil {
 				w.ErrChan <- err
 				log.Error("watch path:%s error:%s, wait 5seconds", dir, err.Error())
@@ -266,14 +464,48 @@ func (cli *ZKClient) WatchDir(dir string) (w *DirWatcher) {
 	return w
 }
 
-func (cli *ZKClient) WatchDirOnce(path string) ([]string, <-chan zk.Event, error) {
+func (cli *ZKClient) WatchDirOnce(npath string) ([]string, <-chan zk.Event, error) {
 	conn := cli.getConn()
 	if conn == nil {
 		return nil, nil, ErrNoZkConnection
 	}
-	children, _, ev, err := conn.ChildrenW(path)
+	fpath, err := cli.fullPath(npath)
+	if err != nil {
+		return nil, nil, err
+	}
+	children, _, ev, err := conn.ChildrenW(fpath)
 	if err != nil {
 		return nil, nil, err
 	}
 	return children, ev, nil
 }
+
+// get data and set data watch, event path is server path
+func (cli *ZKClient) getW(npath string) ([]byte, *zk.Stat, <-chan zk.Event, error) {
+	conn := cli.getConn()
+	if conn == nil {
+		return nil, nil, nil, ErrNoZkConnection
+	}
+	fpath, err := cli.fullPath(npath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return conn.GetW(fpath)
+}
+
+func (cli *ZKClient) childrenW(npath string) ([]string, <-chan zk.Event, error) {
+	return cli.WatchDirOnce(npath)
+}
+
+func (cli *ZKClient) existsW(npath string) (bool, <-chan zk.Event, error) {
+	conn := cli.getConn()
+	if conn == nil {
+		return false, nil, ErrNoZkConnection
+	}
+	fpath, err := cli.fullPath(npath)
+	if err != nil {
+		return false, nil, err
+	}
+	exist, _, ev, err := conn.ExistsW(fpath)
+	return exist, ev, err
+}
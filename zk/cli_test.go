@@ -1,14 +1,37 @@
 package zk
 
 import (
+	"os"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/RivenZoo/goutil/zk/zktest"
 )
 
 var (
 	testServers = []string{"127.0.0.1:2181"}
+	testSrv     *zktest.Server
 )
 
+// run tests with in-memory zookeeper server, unless ZK_SERVERS is set
+// to run with real servers, e.g. ZK_SERVERS=127.0.0.1:2181
+func TestMain(m *testing.M) {
+	if servers := os.Getenv("ZK_SERVERS"); servers != "" {
+		testServers = strings.Split(servers, ",")
+		os.Exit(m.Run())
+	}
+	srv, err := zktest.NewServer()
+	if err != nil {
+		panic(err)
+	}
+	testSrv = srv
+	testServers = srv.Servers()
+	code := m.Run()
+	srv.Close()
+	os.Exit(code)
+}
+
 func TestZkCliConnect(t *testing.T) {
 	cli := NewZKClient(testServers, time.Second*5, nil)
 	defer cli.Close()
@@ -149,3 +172,63 @@ func TestWatchDir(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestNodeData(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	node := "/test/data"
+	_, err := cli.CreateWithData(node, []byte("v1"), ModePersistent)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer cli.DeleteNode(node)
+
+	data, version, err := cli.GetData(node)
+	if err != nil || string(data) != "v1" {
+		t.Log(err, string(data))
+		t.FailNow()
+	}
+
+	newVersion, err := cli.CompareAndSet(node, []byte("v2"), version)
+	if err != nil || newVersion != version+1 {
+		t.Log(err, newVersion)
+		t.FailNow()
+	}
+	_, err = cli.CompareAndSet(node, []byte("v3"), version)
+	if err != ErrVersionConflict {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	err = cli.SetData(node, []byte("v4"))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	data, _, err = cli.GetData(node)
+	if err != nil || string(data) != "v4" {
+		t.Log(err, string(data))
+		t.Fail()
+	}
+}
+
+func TestCreateSequentialNode(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	prefix := "/test/seq-"
+	p1, err := cli.CreateWithData(prefix, []byte("1"), ModeEphemeralSequential)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	p2, err := cli.CreateWithData(prefix, []byte("2"), ModeEphemeralSequential)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if p1 == p2 || p1 >= p2 {
+		t.Log(p1, p2)
+		t.Fail()
+	}
+}
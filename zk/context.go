@@ -0,0 +1,111 @@
+package zk
+
+import (
+	"context"
+	"sync"
+)
+
+// run fn in another goroutine, return ctx.Err() if ctx done before fn return.
+// zk request can not be aborted, so once caller gave up, undo is called by the same goroutine
+// after fn returns, undo could be nil if fn has no side effect
+func doWithContext(ctx context.Context, fn func() error, undo func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	mutex := &sync.Mutex{}
+	abandoned := false
+	done := make(chan error, 1)
+	go func() {
+		err := fn()
+		mutex.Lock()
+		if !abandoned {
+			done <- err
+			mutex.Unlock()
+			return
+		}
+		mutex.Unlock()
+		if undo != nil {
+			undo()
+		}
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		mutex.Lock()
+		defer mutex.Unlock()
+		select {
+		case err := <-done:
+			// fn returned meanwhile, its result is kept
+			return err
+		default:
+		}
+		abandoned = true
+		return ctx.Err()
+	}
+}
+
+// nodes created after ctx done are deleted
+func (cli *ZKClient) CreatePersistNodeContext(ctx context.Context, npath string) error {
+	fpath, err := cli.fullPath(npath)
+	if err != nil {
+		return err
+	}
+	var created []string
+	return doWithContext(ctx, func() error {
+		var err error
+		created, err = cli.createNodes(fpath, int32(0))
+		return err
+	}, func() {
+		cli.deleteNodes(created)
+	})
+}
+
+// nodes created after ctx done are deleted
+func (cli *ZKClient) CreateEphemeralNodeContext(ctx context.Context, npath string) error {
+	fpath, err := cli.fullPath(npath)
+	if err != nil {
+		return err
+	}
+	var created []string
+	return doWithContext(ctx, func() error {
+		var err error
+		created, err = cli.createEphemeral(fpath)
+		return err
+	}, func() {
+		cli.deleteNodes(created)
+	})
+}
+
+func (cli *ZKClient) NodeExistContext(ctx context.Context, npath string) (bool, error) {
+	var exist bool
+	err := doWithContext(ctx, func() error {
+		ok, err := cli.NodeExist(npath)
+		exist = ok
+		return err
+	}, nil)
+	if err != nil {
+		return false, err
+	}
+	return exist, nil
+}
+
+// node may still be deleted after ctx done, which is what caller asks for
+func (cli *ZKClient) DeleteNodeContext(ctx context.Context, npath string) error {
+	return doWithContext(ctx, func() error {
+		return cli.DeleteNode(npath)
+	}, nil)
+}
+
+func (cli *ZKClient) GetChildrenContext(ctx context.Context, path string) ([]string, error) {
+	var children []string
+	err := doWithContext(ctx, func() error {
+		c, err := cli.GetChildren(path)
+		children = c
+		return err
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return children, nil
+}
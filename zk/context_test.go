@@ -0,0 +1,130 @@
+package zk
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestDoWithContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	release := make(chan struct{})
+	undone := make(chan struct{})
+	err := doWithContext(ctx, func() error {
+		<-release
+		return nil
+	}, func() {
+		close(undone)
+	})
+	if err != context.DeadlineExceeded {
+		t.Log(err)
+		t.Fail()
+	}
+	select {
+	case <-undone:
+		t.Log("undo before fn return")
+		t.Fail()
+	default:
+	}
+	close(release)
+	<-undone
+
+	errTest := errors.New("test")
+	err = doWithContext(context.Background(), func() error {
+		return errTest
+	}, func() {
+		t.Log("undo without ctx done")
+		t.Fail()
+	})
+	if err != errTest {
+		t.Fail()
+	}
+}
+
+func TestContextCanceled(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := cli.CreatePersistNodeContext(ctx, "/test/persist"); err != context.Canceled {
+		t.Fail()
+	}
+	if _, err := cli.NodeExistContext(ctx, "/test/persist"); err != context.Canceled {
+		t.Fail()
+	}
+	if _, err := cli.GetChildrenContext(ctx, "/test"); err != context.Canceled {
+		t.Fail()
+	}
+}
+
+// conn delays writes while slow is set
+type slowConn struct {
+	net.Conn
+	slow *int32
+}
+
+func (c slowConn) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(c.slow) != 0 {
+		time.Sleep(time.Millisecond * 100)
+	}
+	return c.Conn.Write(b)
+}
+
+func TestCreateContextUndo(t *testing.T) {
+	var slow int32
+	cli := NewZKClient(testServers, time.Second*5, func(network, address string, timeout time.Duration) (net.Conn, error) {
+		c, err := net.DialTimeout(network, address, timeout)
+		if err != nil {
+			return nil, err
+		}
+		return slowConn{Conn: c, slow: &slow}, nil
+	})
+	defer cli.Close()
+
+	creates := map[string]func(context.Context, string) error{
+		"/test/undo-ephemeral": cli.CreateEphemeralNodeContext,
+		"/test/undo-persist":   cli.CreatePersistNodeContext,
+	}
+	for dir, create := range creates {
+		npath := dir + "/node"
+		_, ev, err := cli.existsW(npath)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		atomic.StoreInt32(&slow, 1)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		err = create(ctx, npath)
+		cancel()
+		atomic.StoreInt32(&slow, 0)
+		if err != context.DeadlineExceeded {
+			t.Log(npath, err)
+			t.Fail()
+		}
+		if e := <-ev; e.Type != zk.EventNodeCreated {
+			t.Log(npath, e)
+			t.FailNow()
+		}
+
+		// node and parent created after ctx done are deleted in background
+		for i := 0; ; i++ {
+			exist, err := cli.NodeExist(dir)
+			if err == nil && !exist {
+				break
+			}
+			if i >= 100 {
+				t.Log("created nodes not deleted", dir, exist, err)
+				t.FailNow()
+			}
+			time.Sleep(time.Millisecond * 50)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package zk
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+var ErrInvalidCounter = errors.New("zk counter data invalid")
+
+// distributed int64 counter, value is saved as decimal string in node data
+// and updated by compare and set on data version, retry on conflict
+type Counter struct {
+	cli  *ZKClient
+	path string
+}
+
+func NewCounter(cli *ZKClient, npath string) *Counter {
+	return &Counter{
+		cli:  cli,
+		path: JoinPath(npath),
+	}
+}
+
+func parseCounter(data []byte) (int64, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	v, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return 0, ErrInvalidCounter
+	}
+	return v, nil
+}
+
+// return 0 if counter node not exist
+func (c *Counter) Get() (int64, error) {
+	data, _, err := c.cli.GetData(c.path)
+	if err == zk.ErrNoNode {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return parseCounter(data)
+}
+
+// add delta to counter and return new value, counter node is created if not exist
+func (c *Counter) Add(delta int64) (int64, error) {
+	for {
+		data, version, err := c.cli.GetData(c.path)
+		if err == zk.ErrNoNode {
+			_, err = c.cli.CreateWithData(c.path, []byte(strconv.FormatInt(delta, 10)), ModePersistent)
+			if err == zk.ErrNodeExists {
+				continue
+			}
+			if err != nil {
+				return 0, err
+			}
+			return delta, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		v, err := parseCounter(data)
+		if err != nil {
+			return 0, err
+		}
+		v += delta
+		_, err = c.cli.CompareAndSet(c.path, []byte(strconv.FormatInt(v, 10)), version)
+		if err == ErrVersionConflict {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		return v, nil
+	}
+}
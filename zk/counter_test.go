@@ -0,0 +1,51 @@
+package zk
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCounter(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	npath := "/test/counter"
+	defer cli.DeleteNode("/test/counter")
+
+	c := NewCounter(cli, npath)
+	if v, err := c.Get(); err != nil || v != 0 {
+		t.Log(v, err)
+		t.FailNow()
+	}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			other := NewZKClient(testServers, time.Second*5, nil)
+			defer other.Close()
+			oc := NewCounter(other, npath)
+			for j := 0; j < 10; j++ {
+				if _, err := oc.Add(2); err != nil {
+					t.Log(err)
+					t.Fail()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if v, err := c.Add(-1); err != nil || v != 99 {
+		t.Log(v, err)
+		t.Fail()
+	}
+	if v, err := c.Get(); err != nil || v != 99 {
+		t.Log(v, err)
+		t.Fail()
+	}
+
+	cli.SetData(npath, []byte("x"))
+	if _, err := c.Add(1); err != ErrInvalidCounter {
+		t.Log(err)
+		t.Fail()
+	}
+}
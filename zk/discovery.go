@@ -0,0 +1,318 @@
+package zk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/alecthomas/log4go"
+	"gopkg.in/yaml.v2"
+)
+
+// address monitors without zookeeper, used by NewMonitor.
+// StaticAddrMonitor: fixed address list
+// FileAddrMonitor: address list in json, yaml or line based file, reload when file changed
+// DNSAddrMonitor: address resolved by A or SRV record, resolved on interval
+
+var errMonitorClosed = errors.New("address monitor closed")
+
+// feed address list to channels returned by ValidAddr and ValidAddrWithMeta,
+// new channel receives latest address list at once, only latest list is kept if not read in time
+type addrFeed struct {
+	lock      *sync.Mutex
+	closed    bool
+	last      []ServiceAddr
+	published bool
+	addrChans []chan []string
+	metaChans []chan []ServiceAddr
+	stop      chan struct{}
+}
+
+func newAddrFeed() *addrFeed {
+	return &addrFeed{
+		lock: &sync.Mutex{},
+		stop: make(chan struct{}),
+	}
+}
+
+func addrsOf(servAddrs []ServiceAddr) []string {
+	addrs := make([]string, 0, len(servAddrs))
+	for _, sa := range servAddrs {
+		addrs = append(addrs, sa.Addr)
+	}
+	return addrs
+}
+
+func (f *addrFeed) ValidAddr() (<-chan []string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.closed {
+		return nil, errMonitorClosed
+	}
+	ch := make(chan []string, 1)
+	if f.published {
+		ch <- addrsOf(f.last)
+	}
+	f.addrChans = append(f.addrChans, ch)
+	return ch, nil
+}
+
+func (f *addrFeed) ValidAddrWithMeta() (<-chan []ServiceAddr, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.closed {
+		return nil, errMonitorClosed
+	}
+	ch := make(chan []ServiceAddr, 1)
+	if f.published {
+		ch <- f.last
+	}
+	f.metaChans = append(f.metaChans, ch)
+	return ch, nil
+}
+
+// publish address list if it changed, never blocks.
+// list not read yet is replaced, so that slow reader only misses stale lists
+func (f *addrFeed) publish(servAddrs []ServiceAddr) {
+	sort.Slice(servAddrs, func(i, j int) bool {
+		return servAddrs[i].Addr < servAddrs[j].Addr
+	})
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.closed || (f.published && reflect.DeepEqual(f.last, servAddrs)) {
+		return
+	}
+	f.last = servAddrs
+	f.published = true
+	// publish is the only sender and holds lock, so channel has room after drained
+	for _, ch := range f.addrChans {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- addrsOf(servAddrs)
+	}
+	for _, ch := range f.metaChans {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- servAddrs
+	}
+}
+
+func (f *addrFeed) Close() {
+	select {
+	case <-f.stop:
+		return
+	default:
+	}
+	close(f.stop)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.closed = true
+	for _, ch := range f.addrChans {
+		close(ch)
+	}
+	for _, ch := range f.metaChans {
+		close(ch)
+	}
+}
+
+// run fn on interval until feed closed
+func (f *addrFeed) poll(interval time.Duration, fn func()) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				fn()
+			case <-f.stop:
+				return
+			}
+		}
+	}()
+}
+
+type StaticAddrMonitor struct {
+	*addrFeed
+}
+
+func NewStaticAddrMonitor(addrs []string) *StaticAddrMonitor {
+	return NewStaticAddrMonitorWithMeta(toServiceAddrs(addrs))
+}
+
+func NewStaticAddrMonitorWithMeta(addrs []ServiceAddr) *StaticAddrMonitor {
+	m := &StaticAddrMonitor{newAddrFeed()}
+	m.publish(addrs)
+	return m
+}
+
+const DefaultPollInterval = 5 * time.Second
+
+type fileAddrEntry struct {
+	Addr string       `json:"addr" yaml:"addr"`
+	Meta *ServiceMeta `json:"meta" yaml:"meta"`
+}
+
+// file is parsed by extension:
+// .json: ["addr1","addr2"] or [{"addr":"addr1","meta":{"weight":2}}]
+// .yaml/.yml: same as json in yaml format
+// others: one address each line, empty line and line begin with # are skipped
+type FileAddrMonitor struct {
+	*addrFeed
+	path    string
+	content []byte
+}
+
+// file is checked on interval and reloaded if content changed
+func NewFileAddrMonitor(path string, interval time.Duration) (*FileAddrMonitor, error) {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	m := &FileAddrMonitor{
+		addrFeed: newAddrFeed(),
+		path:     path,
+	}
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+	m.poll(interval, func() {
+		if err := m.reload(); err != nil {
+			log.Error("reload address file:%s error:%v", m.path, err)
+		}
+	})
+	return m, nil
+}
+
+func (m *FileAddrMonitor) reload() error {
+	content, err := ioutil.ReadFile(m.path)
+	if err != nil {
+		return err
+	}
+	if m.content != nil && bytes.Equal(content, m.content) {
+		return nil
+	}
+	addrs, err := parseAddrFile(m.path, content)
+	if err != nil {
+		return err
+	}
+	m.content = content
+	m.publish(addrs)
+	return nil
+}
+
+func parseAddrFile(path string, content []byte) ([]ServiceAddr, error) {
+	var unmarshal func([]byte, interface{}) error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		unmarshal = json.Unmarshal
+	case ".yaml", ".yml":
+		unmarshal = yaml.Unmarshal
+	default:
+		return parseAddrLines(content), nil
+	}
+
+	var addrs []string
+	if err := unmarshal(content, &addrs); err == nil {
+		return toServiceAddrs(addrs), nil
+	}
+	var entries []fileAddrEntry
+	if err := unmarshal(content, &entries); err != nil {
+		return nil, err
+	}
+	servAddrs := make([]ServiceAddr, 0, len(entries))
+	for _, e := range entries {
+		servAddrs = append(servAddrs, ServiceAddr{Addr: e.Addr, Meta: e.Meta})
+	}
+	return servAddrs, nil
+}
+
+func parseAddrLines(content []byte) []ServiceAddr {
+	servAddrs := make([]ServiceAddr, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		servAddrs = append(servAddrs, ServiceAddr{Addr: line})
+	}
+	return servAddrs
+}
+
+// resolve address by dns on interval, keep last address list if resolve failed
+type DNSAddrMonitor struct {
+	*addrFeed
+	name   string
+	lookup func() ([]ServiceAddr, error)
+}
+
+// resolve A/AAAA record of host, address is ip:port
+func NewDNSAddrMonitor(host string, port int, interval time.Duration) *DNSAddrMonitor {
+	lookup := func() ([]ServiceAddr, error) {
+		ips, err := net.LookupHost(host)
+		if err != nil {
+			return nil, err
+		}
+		servAddrs := make([]ServiceAddr, 0, len(ips))
+		for _, ip := range ips {
+			servAddrs = append(servAddrs, ServiceAddr{Addr: net.JoinHostPort(ip, strconv.Itoa(port))})
+		}
+		return servAddrs, nil
+	}
+	return newDNSAddrMonitor(host, interval, lookup)
+}
+
+// resolve SRV record _service._proto.name, address is target:port, SRV weight is used as meta weight
+func NewDNSSRVAddrMonitor(service, proto, name string, interval time.Duration) *DNSAddrMonitor {
+	lookup := func() ([]ServiceAddr, error) {
+		_, srvs, err := net.LookupSRV(service, proto, name)
+		if err != nil {
+			return nil, err
+		}
+		servAddrs := make([]ServiceAddr, 0, len(srvs))
+		for _, srv := range srvs {
+			servAddrs = append(servAddrs, ServiceAddr{
+				Addr: net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))),
+				Meta: &ServiceMeta{Weight: int(srv.Weight)},
+			})
+		}
+		return servAddrs, nil
+	}
+	return newDNSAddrMonitor(name, interval, lookup)
+}
+
+func newDNSAddrMonitor(name string, interval time.Duration, lookup func() ([]ServiceAddr, error)) *DNSAddrMonitor {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	m := &DNSAddrMonitor{
+		addrFeed: newAddrFeed(),
+		name:     name,
+		lookup:   lookup,
+	}
+	m.resolve()
+	m.poll(interval, m.resolve)
+	return m
+}
+
+func (m *DNSAddrMonitor) resolve() {
+	addrs, err := m.lookup()
+	if err != nil {
+		log.Error("resolve dns:%s error:%v", m.name, err)
+		return
+	}
+	m.publish(addrs)
+}
@@ -0,0 +1,155 @@
+package zk
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStaticAddrMonitor(t *testing.T) {
+	m := NewStaticAddrMonitor([]string{"b", "a"})
+	zkm := NewMonitor(m, &testAddrService{}, nil)
+	zkm.Run()
+	defer zkm.Close()
+	time.Sleep(time.Millisecond * 100)
+
+	checkAddrs([]string{"a", "b"}, zkm, t)
+	conn := zkm.RoundTripGetter().GetConn()
+	if conn == nil {
+		t.FailNow()
+	}
+	t.Log(zkm.Status())
+
+	ch, err := m.ValidAddr()
+	if err != nil {
+		t.FailNow()
+	}
+	addrs := <-ch
+	if len(addrs) != 2 || addrs[0] != "a" {
+		t.Log(addrs)
+		t.Fail()
+	}
+}
+
+func TestAddrFeedSlowReader(t *testing.T) {
+	f := newAddrFeed()
+	defer f.Close()
+	slow, _ := f.ValidAddr()
+	for i := 0; i < 10; i++ {
+		f.publish([]ServiceAddr{{Addr: fmt.Sprint(i)}})
+	}
+	// slow reader does not block others and only gets latest list
+	ch, _ := f.ValidAddrWithMeta()
+	if addrs := <-ch; len(addrs) != 1 || addrs[0].Addr != "9" {
+		t.Log(addrs)
+		t.Fail()
+	}
+	if addrs := <-slow; len(addrs) != 1 || addrs[0] != "9" {
+		t.Log(addrs)
+		t.Fail()
+	}
+}
+
+func TestParseAddrFile(t *testing.T) {
+	cases := []struct {
+		path    string
+		content string
+	}{
+		{"addr.json", `["10.0.0.1:80","10.0.0.2:80"]`},
+		{"addr.json", `[{"addr":"10.0.0.1:80","meta":{"weight":2}},{"addr":"10.0.0.2:80"}]`},
+		{"addr.yaml", "- 10.0.0.1:80\n- 10.0.0.2:80\n"},
+		{"addr.yml", "- addr: 10.0.0.1:80\n  meta:\n    weight: 2\n- addr: 10.0.0.2:80\n"},
+		{"addr.txt", "# backends\n10.0.0.1:80\n\n 10.0.0.2:80 \n"},
+	}
+	for _, c := range cases {
+		addrs, err := parseAddrFile(c.path, []byte(c.content))
+		if err != nil || len(addrs) != 2 || addrs[0].Addr != "10.0.0.1:80" || addrs[1].Addr != "10.0.0.2:80" {
+			t.Log(c.path, c.content, addrs, err)
+			t.Fail()
+		}
+	}
+	if _, err := parseAddrFile("addr.json", []byte("{")); err == nil {
+		t.Fail()
+	}
+}
+
+func TestFileAddrMonitor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "addr")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "addr.json")
+	ioutil.WriteFile(path, []byte(`[{"addr":"a","meta":{"weight":3}}]`), 0644)
+
+	m, err := NewFileAddrMonitor(path, time.Millisecond*10)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer m.Close()
+	ch, _ := m.ValidAddrWithMeta()
+	addrs := <-ch
+	if len(addrs) != 1 || addrs[0].Meta.Weight != 3 {
+		t.Log(addrs)
+		t.FailNow()
+	}
+
+	ioutil.WriteFile(path, []byte(`["a","b"]`), 0644)
+	select {
+	case addrs = <-ch:
+		if len(addrs) != 2 || addrs[0].Meta != nil {
+			t.Log(addrs)
+			t.Fail()
+		}
+	case <-time.After(time.Second):
+		t.Fail()
+	}
+
+	if _, err := NewFileAddrMonitor(filepath.Join(dir, "none"), 0); err == nil {
+		t.Fail()
+	}
+}
+
+func TestDNSAddrMonitor(t *testing.T) {
+	results := make(chan []ServiceAddr, 2)
+	results <- []ServiceAddr{{Addr: "10.0.0.1:53"}}
+	lookup := func() ([]ServiceAddr, error) {
+		select {
+		case addrs := <-results:
+			return addrs, nil
+		default:
+			return nil, errors.New("no record")
+		}
+	}
+	m := newDNSAddrMonitor("test", time.Millisecond*10, lookup)
+	defer m.Close()
+	ch, _ := m.ValidAddr()
+	addrs := <-ch
+	if len(addrs) != 1 || addrs[0] != "10.0.0.1:53" {
+		t.FailNow()
+	}
+
+	results <- []ServiceAddr{{Addr: "10.0.0.2:53"}, {Addr: "10.0.0.1:53"}}
+	addrs = <-ch
+	if len(addrs) != 2 || addrs[0] != "10.0.0.1:53" {
+		t.Log(addrs)
+		t.Fail()
+	}
+}
+
+func TestDNSLocalhost(t *testing.T) {
+	m := NewDNSAddrMonitor("localhost", 80, time.Minute)
+	defer m.Close()
+	ch, _ := m.ValidAddr()
+	select {
+	case addrs := <-ch:
+		t.Log(addrs)
+	case <-time.After(time.Millisecond * 100):
+		t.Log("localhost not resolved")
+	}
+}
@@ -0,0 +1,308 @@
+package zk
+
+import (
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	log "github.com/alecthomas/log4go"
+)
+
+// active health check and passive outlier ejection of ZKMonitor service cli.
+// cli is disabled when it is unhealthy or ejected, even though its address still exist,
+// and enabled again after it recovers.
+
+const (
+	defaultUnhealthyThreshold = 1
+	defaultHealthCheckTimeout = 5 * time.Second
+	defaultBaseEjectTime      = 30 * time.Second
+	defaultMaxEjectTime       = 5 * time.Minute
+	ejectCheckInterval        = time.Second
+)
+
+var ErrHealthCheckTimeout = errors.New("zk service health check timeout")
+
+// optional interface of ServiceCli, called on interval if active health check enabled
+type HealthChecker interface {
+	HealthCheck() error
+}
+
+type HealthCheckOption struct {
+	Interval           time.Duration // active health check interval, 0 means no active check
+	Timeout            time.Duration // check not returned in time fails, default Interval or 5s
+	UnhealthyThreshold int           // consecutive check failures to disable cli, default 1
+	EjectThreshold     int           // consecutive reported failures to eject cli, 0 means no ejection
+	BaseEjectTime      time.Duration // eject time is doubled every ejection, default 30s
+	MaxEjectTime       time.Duration // default 5min
+}
+
+// reportErrs, ejectCount and checking are atomic, others are guarded by ZKMonitor.servLock
+type servCliHealth struct {
+	removed      bool // address not exist
+	unhealthy    bool
+	checkFails   int
+	lastErr      string
+	reportErrs   int32
+	ejectCount   int32
+	checking     int32 // HealthCheck not returned
+	ejectedUntil time.Time
+}
+
+func (h *servCliHealth) available(now time.Time) bool {
+	return !h.removed && !h.unhealthy && !now.Before(h.ejectedUntil)
+}
+
+func (h *servCliHealth) String() string {
+	status := struct {
+		Healthy    bool   `json:"healthy"`
+		CheckFails int    `json:"checkFails"`
+		LastErr    string `json:"lastErr,omitempty"`
+		Ejected    bool   `json:"ejected"`
+		EjectCount int    `json:"ejectCnt"`
+	}{
+		Healthy:    !h.unhealthy,
+		CheckFails: h.checkFails,
+		LastErr:    h.lastErr,
+		Ejected:    time.Now().Before(h.ejectedUntil),
+		EjectCount: int(atomic.LoadInt32(&h.ejectCount)),
+	}
+	s, err := json.Marshal(status)
+	if err != nil {
+		return "{}"
+	}
+	return string(s)
+}
+
+// should be called before Run
+func (zkm *ZKMonitor) SetHealthCheck(opt HealthCheckOption) {
+	if opt.UnhealthyThreshold <= 0 {
+		opt.UnhealthyThreshold = defaultUnhealthyThreshold
+	}
+	if opt.Timeout <= 0 {
+		opt.Timeout = opt.Interval
+		if opt.Timeout <= 0 {
+			opt.Timeout = defaultHealthCheckTimeout
+		}
+	}
+	if opt.BaseEjectTime <= 0 {
+		opt.BaseEjectTime = defaultBaseEjectTime
+	}
+	if opt.MaxEjectTime <= 0 {
+		opt.MaxEjectTime = defaultMaxEjectTime
+	}
+	zkm.healthOpt = opt
+}
+
+// enable cli if available, otherwise disable it, servLock should be held
+// and notify should be called after servLock released
+func (zkm *ZKMonitor) refreshValid(c *monitorServCli) {
+	if c.state.health.available(time.Now()) {
+		c.enable()
+	} else {
+		c.disable()
+	}
+}
+
+func (zkm *ZKMonitor) healthLoop() {
+	defer close(zkm.healthExited)
+
+	var check, eject <-chan time.Time
+	if zkm.healthOpt.Interval > 0 {
+		t := time.NewTicker(zkm.healthOpt.Interval)
+		defer t.Stop()
+		check = t.C
+	}
+	if zkm.healthOpt.EjectThreshold > 0 {
+		t := time.NewTicker(ejectCheckInterval)
+		defer t.Stop()
+		eject = t.C
+	}
+	for {
+		select {
+		case <-check:
+			zkm.checkHealth()
+		case now := <-eject:
+			zkm.restoreEjected(now)
+		case <-zkm.healthStop:
+			return
+		}
+	}
+}
+
+type healthResult struct {
+	idx int
+	err error
+}
+
+// run HealthCheck of all cli whose address exist concurrently, check not returned
+// in HealthCheckOption.Timeout fails, and it is not run again until returned
+func (zkm *ZKMonitor) checkHealth() {
+	zkm.servLock.RLock()
+	targets := make([]monitorServCli, 0, len(zkm.servCli))
+	for _, c := range zkm.servCli {
+		if _, ok := c.cli.(HealthChecker); ok && !c.state.health.removed {
+			targets = append(targets, c)
+		}
+	}
+	zkm.servLock.RUnlock()
+
+	results := make([]error, len(targets))
+	resultCh := make(chan healthResult, len(targets))
+	pending := 0
+	for i := range targets {
+		results[i] = ErrHealthCheckTimeout
+		h := &targets[i].state.health
+		if !atomic.CompareAndSwapInt32(&h.checking, 0, 1) {
+			continue
+		}
+		pending++
+		go func(i int, hc HealthChecker) {
+			err := hc.HealthCheck()
+			atomic.StoreInt32(&h.checking, 0)
+			resultCh <- healthResult{idx: i, err: err}
+		}(i, targets[i].cli.(HealthChecker))
+	}
+	timer := time.NewTimer(zkm.healthOpt.Timeout)
+	defer timer.Stop()
+wait:
+	for ; pending > 0; pending-- {
+		select {
+		case r := <-resultCh:
+			results[r.idx] = r.err
+		case <-timer.C:
+			break wait
+		}
+	}
+
+	zkm.servLock.Lock()
+	for i := range targets {
+		c := &targets[i]
+		h := &c.state.health
+		if err := results[i]; err != nil {
+			h.checkFails++
+			h.lastErr = err.Error()
+			if h.checkFails >= zkm.healthOpt.UnhealthyThreshold && !h.unhealthy {
+				log.Error("health check addr:%s failed %d times, error:%v", c.addr, h.checkFails, err)
+				h.unhealthy = true
+			}
+		} else {
+			if h.unhealthy {
+				log.Info("health check addr:%s recovered", c.addr)
+			}
+			h.checkFails = 0
+			h.lastErr = ""
+			h.unhealthy = false
+		}
+		zkm.refreshValid(c)
+	}
+	zkm.servLock.Unlock()
+
+	for i := range targets {
+		targets[i].notify()
+	}
+}
+
+func (zkm *ZKMonitor) restoreEjected(now time.Time) {
+	var restored []monitorServCli
+	zkm.servLock.Lock()
+	for i := range zkm.servCli {
+		c := &zkm.servCli[i]
+		h := &c.state.health
+		if h.ejectedUntil.IsZero() || now.Before(h.ejectedUntil) {
+			continue
+		}
+		log.Info("restore ejected addr:%s", c.addr)
+		h.ejectedUntil = time.Time{}
+		atomic.StoreInt32(&h.reportErrs, 0)
+		zkm.refreshValid(c)
+		restored = append(restored, *c)
+	}
+	zkm.servLock.Unlock()
+
+	for i := range restored {
+		restored[i].notify()
+	}
+}
+
+// report failure of connection got from addr, cli is ejected after
+// HealthCheckOption.EjectThreshold consecutive failures.
+// addr is returned by GetConnAddr of getters, servLock is locked exclusively only to eject
+func (zkm *ZKMonitor) ReportFailure(addr string) {
+	if zkm.healthOpt.EjectThreshold <= 0 {
+		return
+	}
+	h := zkm.health(addr)
+	if h == nil {
+		return
+	}
+	// only the report reaching threshold ejects
+	if atomic.AddInt32(&h.reportErrs, 1) != int32(zkm.healthOpt.EjectThreshold) {
+		return
+	}
+	zkm.servLock.Lock()
+	c, ejected := zkm.eject(addr)
+	zkm.servLock.Unlock()
+	if ejected {
+		c.notify()
+	}
+}
+
+func (zkm *ZKMonitor) health(addr string) *servCliHealth {
+	zkm.servLock.RLock()
+	defer zkm.servLock.RUnlock()
+	idx, ok := zkm.servIdx[addr]
+	if !ok {
+		return nil
+	}
+	return &zkm.servCli[idx].state.health
+}
+
+// return true if cli is ejected, servLock should be held.
+// failures are reset, include those reported while ejected
+func (zkm *ZKMonitor) eject(addr string) (monitorServCli, bool) {
+	idx, ok := zkm.servIdx[addr]
+	if !ok {
+		return monitorServCli{}, false
+	}
+	c := &zkm.servCli[idx]
+	h := &c.state.health
+	atomic.StoreInt32(&h.reportErrs, 0)
+	now := time.Now()
+	if now.Before(h.ejectedUntil) {
+		return *c, false
+	}
+
+	ejectCount := atomic.AddInt32(&h.ejectCount, 1)
+	ejectTime := zkm.healthOpt.MaxEjectTime
+	if ejectCount <= 16 {
+		if d := zkm.healthOpt.BaseEjectTime << uint(ejectCount-1); d < ejectTime {
+			ejectTime = d
+		}
+	}
+	h.ejectedUntil = now.Add(ejectTime)
+	log.Error("eject addr:%s for %s, eject count:%d", addr, ejectTime, ejectCount)
+	zkm.refreshValid(c)
+	return *c, true
+}
+
+// report success of connection got from addr, reset consecutive failures
+// addr is returned by GetConnAddr of getters
+func (zkm *ZKMonitor) ReportSuccess(addr string) {
+	if zkm.healthOpt.EjectThreshold <= 0 {
+		return
+	}
+	zkm.servLock.RLock()
+	defer zkm.servLock.RUnlock()
+	idx, ok := zkm.servIdx[addr]
+	if !ok {
+		return
+	}
+	h := &zkm.servCli[idx].state.health
+	if atomic.LoadInt32(&h.reportErrs) != 0 {
+		atomic.StoreInt32(&h.reportErrs, 0)
+	}
+	if h.ejectedUntil.IsZero() && atomic.LoadInt32(&h.ejectCount) != 0 {
+		atomic.StoreInt32(&h.ejectCount, 0)
+	}
+}
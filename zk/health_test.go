@@ -0,0 +1,223 @@
+package zk
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testHealthService struct {
+	testAddrService
+	fail *int32
+	hang chan struct{} // check of "a" blocks until closed if not nil
+}
+
+func (t *testHealthService) InitCli(addr string, arg interface{}) ServiceCli {
+	return &testHealthService{testAddrService{addr: addr}, t.fail, t.hang}
+}
+func (t *testHealthService) HealthCheck() error {
+	if t.hang != nil && t.addr == "a" {
+		<-t.hang
+	}
+	if atomic.LoadInt32(t.fail) != 0 && t.addr == "a" {
+		return errors.New("unhealthy")
+	}
+	return nil
+}
+
+func TestHealthCheck(t *testing.T) {
+	fail := int32(1)
+	zkm := newTestMonitor(&testHealthService{fail: &fail})
+	zkm.SetHealthCheck(HealthCheckOption{UnhealthyThreshold: 2})
+	zkm.onAddrChange([]string{"a", "b"})
+	rtg := zkm.RoundTripGetter()
+
+	zkm.checkHealth()
+	checkAddrs([]string{"a", "b"}, zkm, t)
+	zkm.checkHealth()
+	checkAddrs([]string{"b"}, zkm, t)
+	for i := 0; i < 4; i++ {
+		if rtg.GetConn().(string) != "b" {
+			t.FailNow()
+		}
+	}
+	t.Log(zkm.Status())
+
+	// address change should not enable unhealthy cli
+	zkm.onAddrChange([]string{"a", "b"})
+	checkAddrs([]string{"b"}, zkm, t)
+
+	atomic.StoreInt32(&fail, 0)
+	zkm.checkHealth()
+	checkAddrs([]string{"a", "b"}, zkm, t)
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+	fail := int32(0)
+	serv := &testHealthService{fail: &fail, hang: make(chan struct{})}
+	zkm := newTestMonitor(serv)
+	zkm.SetHealthCheck(HealthCheckOption{Timeout: time.Millisecond * 50})
+	zkm.onAddrChange([]string{"a", "b"})
+
+	// hung check fails and is not run again until returned
+	for i := 0; i < 2; i++ {
+		zkm.checkHealth()
+		checkAddrs([]string{"b"}, zkm, t)
+	}
+	close(serv.hang)
+	for i := 0; ; i++ {
+		zkm.checkHealth()
+		zkm.servLock.RLock()
+		unhealthy := zkm.servCli[zkm.servIdx["a"]].state.health.unhealthy
+		zkm.servLock.RUnlock()
+		if !unhealthy {
+			break
+		}
+		if i >= 100 {
+			t.Log("hung check not recovered")
+			t.FailNow()
+		}
+		// let hung check return
+		time.Sleep(time.Millisecond * 10)
+	}
+	checkAddrs([]string{"a", "b"}, zkm, t)
+}
+
+func TestOutlierEject(t *testing.T) {
+	zkm := newTestMonitor(&testAddrService{})
+	zkm.SetHealthCheck(HealthCheckOption{EjectThreshold: 3, BaseEjectTime: time.Minute})
+	zkm.onAddrChange([]string{"a", "b"})
+
+	zkm.ReportFailure("a")
+	zkm.ReportFailure("a")
+	zkm.ReportSuccess("a")
+	zkm.ReportFailure("a")
+	zkm.ReportFailure("a")
+	checkAddrs([]string{"a", "b"}, zkm, t)
+	zkm.ReportFailure("a")
+	checkAddrs([]string{"b"}, zkm, t)
+	t.Log(zkm.Status())
+
+	h := &zkm.servCli[zkm.servIdx["a"]].state.health
+	if d := time.Until(h.ejectedUntil); d < time.Second*59 || d > time.Minute {
+		t.Log(d)
+		t.FailNow()
+	}
+	zkm.restoreEjected(time.Now())
+	checkAddrs([]string{"b"}, zkm, t)
+	zkm.restoreEjected(time.Now().Add(time.Minute))
+	checkAddrs([]string{"a", "b"}, zkm, t)
+
+	// eject time doubled
+	for i := 0; i < 3; i++ {
+		zkm.ReportFailure("a")
+	}
+	if d := time.Until(h.ejectedUntil); d < time.Minute*2-time.Second {
+		t.Log(d)
+		t.Fail()
+	}
+}
+
+func TestReportByGetterAddr(t *testing.T) {
+	zkm := newTestMonitor(&testAddrService{})
+	zkm.SetHealthCheck(HealthCheckOption{EjectThreshold: 2, BaseEjectTime: time.Minute})
+	zkm.onAddrChange([]string{"a", "b"})
+
+	// every getter returns address of conn, report failure of "a" only
+	getters := []func() (ServiceConn, string){
+		zkm.RoundTripGetter().GetConnAddr,
+		zkm.WeightedGetter().GetConnAddr,
+		func() (ServiceConn, string) {
+			conn, addr, release := zkm.LeastConnGetter().GetConnAddr()
+			release()
+			return conn, addr
+		},
+		func() (ServiceConn, string) {
+			return zkm.HashGetter(nil).GetConnAddr([]byte("key"))
+		},
+		func() (ServiceConn, string) {
+			return zkm.ConsistentHashGetter(ConsistentHashOption{}).GetConnAddr([]byte("key"))
+		},
+	}
+	for _, get := range getters {
+		conn, addr := get()
+		if conn == nil || conn.(string) != addr {
+			t.Log(conn, addr)
+			t.FailNow()
+		}
+		if addr == "a" {
+			zkm.ReportFailure(addr)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		if _, addr := zkm.RoundTripGetter().GetConnAddr(); addr == "a" {
+			zkm.ReportFailure(addr)
+		}
+	}
+	checkAddrs([]string{"b"}, zkm, t)
+	for _, get := range getters {
+		if _, addr := get(); addr != "b" {
+			t.Log(addr)
+			t.Fail()
+		}
+	}
+}
+
+type testNotifyService struct {
+	testAddrService
+	init     chan struct{}
+	enabled  *int32
+	disabled *int32
+}
+
+func (t *testNotifyService) InitCli(addr string, arg interface{}) ServiceCli {
+	if t.init != nil {
+		<-t.init
+	}
+	return &testNotifyService{testAddrService: testAddrService{addr: addr}, enabled: t.enabled, disabled: t.disabled}
+}
+func (t *testNotifyService) OnEnable() {
+	atomic.AddInt32(t.enabled, 1)
+}
+func (t *testNotifyService) OnDisable() {
+	atomic.AddInt32(t.disabled, 1)
+}
+
+func TestServiceChangeWithoutLock(t *testing.T) {
+	var enabled, disabled int32
+	serv := &testNotifyService{init: make(chan struct{}), enabled: &enabled, disabled: &disabled}
+	zkm := newTestMonitor(serv)
+	zkm.SetHealthCheck(HealthCheckOption{EjectThreshold: 1, BaseEjectTime: time.Minute})
+
+	changed := make(chan struct{})
+	go func() {
+		zkm.onAddrChange([]string{"a", "b"})
+		close(changed)
+	}()
+	// blocked InitCli does not hold servLock
+	done := make(chan struct{})
+	go func() {
+		zkm.Status()
+		zkm.ReportFailure("a")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Log("blocked by InitCli")
+		t.FailNow()
+	}
+	close(serv.init)
+	<-changed
+	checkAddrs([]string{"a", "b"}, zkm, t)
+
+	zkm.ReportFailure("a")
+	zkm.onAddrChange([]string{"a"})
+	zkm.restoreEjected(time.Now().Add(time.Minute))
+	checkAddrs([]string{"a"}, zkm, t)
+	if atomic.LoadInt32(&enabled) != 1 || atomic.LoadInt32(&disabled) != 2 {
+		t.Log(enabled, disabled)
+		t.Fail()
+	}
+}
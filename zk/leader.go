@@ -0,0 +1,239 @@
+package zk
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/alecthomas/log4go"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// leader election on ephemeral sequential nodes under election path.
+// candidate with smallest sequence is leader, others watch their predecessor.
+// leadership is given up once session disconnected, because session may expire unnoticed,
+// and candidate node is created again on session build if it was gone with expired session,
+// just like ZKRegister does for registered address.
+// candidate nodes are protected by guid of elector, orphans left by lost create response are
+// deleted, otherwise they could win election while nobody leads.
+
+const (
+	candidatePrefix = "candidate-"
+	electRetryDelay = 5 * time.Second
+)
+
+var (
+	errElectorRunning = errors.New("leader elector already running")
+	errElectorClosed  = errors.New("leader elector closed")
+	ErrNoLeader       = errors.New("no leader elected")
+)
+
+type LeaderElector struct {
+	running  bool
+	closed   bool
+	cli      *ZKClient
+	path     string
+	meta     []byte
+	id       string // protected node guid
+	mutex    *sync.Mutex
+	node     string
+	leader   int32
+	leaderCh chan bool
+	stop     chan struct{}
+	exited   chan struct{}
+}
+
+// meta is stored as candidate node data, could be read by Leader
+func NewLeaderElector(cli *ZKClient, electionPath string, meta []byte) *LeaderElector {
+	return &LeaderElector{
+		cli:      cli,
+		path:     electionPath,
+		meta:     meta,
+		id:       newProtectedID(),
+		mutex:    &sync.Mutex{},
+		leaderCh: make(chan bool, 1),
+		stop:     make(chan struct{}),
+		exited:   make(chan struct{}),
+	}
+}
+
+// register candidate and start election
+func (e *LeaderElector) Run() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.closed {
+		return errElectorClosed
+	}
+	if e.running {
+		return errElectorRunning
+	}
+	// reuse node left by last failed Run
+	node, err := e.cli.createProtected(e.path, candidatePrefix, e.id, e.meta, true)
+	if err != nil {
+		return err
+	}
+	e.node = node
+	e.running = true
+
+	sw := e.cli.WatchSessionState()
+	sched := make(chan struct{})
+	go func() {
+		close(sched)
+		e.loop(sw)
+	}()
+	<-sched
+	return nil
+}
+
+// resign leadership and delete candidate node
+func (e *LeaderElector) Close() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.closed {
+		return
+	}
+	e.closed = true
+	if !e.running {
+		return
+	}
+	close(e.stop)
+	<-e.exited
+}
+
+func (e *LeaderElector) IsLeader() bool {
+	return atomic.LoadInt32(&e.leader) == validMark
+}
+
+// receive true on leadership gained and false on lost
+// only latest leadership state is kept if not read in time
+func (e *LeaderElector) Leadership() <-chan bool {
+	return e.leaderCh
+}
+
+// return meta of current leader
+func (e *LeaderElector) Leader() ([]byte, error) {
+	children, err := e.cli.GetChildren(e.path)
+	if err != nil {
+		return nil, err
+	}
+	nodes := sortBySequence(children)
+	if len(nodes) == 0 {
+		return nil, ErrNoLeader
+	}
+	data, _, err := e.cli.GetData(JoinPath(e.path, nodes[0]))
+	return data, err
+}
+
+func (e *LeaderElector) setLeader(leader bool) {
+	mark := invalidMark
+	if leader {
+		mark = validMark
+	}
+	if atomic.SwapInt32(&e.leader, mark) == mark {
+		return
+	}
+	log.Info("election path:%s node:%s leader:%t", e.path, e.node, leader)
+	// drop stale state, loop is the only sender so it never blocks
+	select {
+	case <-e.leaderCh:
+	default:
+	}
+	e.leaderCh <- leader
+}
+
+func (e *LeaderElector) loop(sw *StateWatcher) {
+	defer close(e.exited)
+	defer sw.Close()
+
+	var retry <-chan time.Time
+	watch, err := e.elect()
+	if err != nil {
+		retry = time.After(electRetryDelay)
+	}
+	for {
+		select {
+		case ev := <-watch:
+			log.Debug("election path:%s recv event:%s", e.path, ev)
+			watch, err = e.elect()
+		case <-retry:
+			watch, err = e.elect()
+		case s := <-sw.States:
+			switch s.State {
+			case StateDisconnected, StateExpired:
+				e.setLeader(false)
+				continue
+			case StateHasSession:
+				watch, err = e.elect()
+			default:
+				continue
+			}
+		case <-e.stop:
+			e.setLeader(false)
+			if err := e.cli.DeleteNode(e.node); err != nil {
+				log.Error("delete candidate node:%s error:%v", e.node, err)
+			}
+			return
+		}
+		retry = nil
+		if err != nil {
+			log.Error("election path:%s error:%v, retry after %s", e.path, err, electRetryDelay)
+			e.setLeader(false)
+			retry = time.After(electRetryDelay)
+		}
+	}
+}
+
+// check candidates, leader watches its own node and others watch predecessor
+func (e *LeaderElector) elect() (<-chan zk.Event, error) {
+	for {
+		children, err := e.cli.GetChildren(e.path)
+		if err != nil {
+			return nil, err
+		}
+		nodes := sortBySequence(children)
+		prefix := protectedName(e.id, "")
+		idx := -1
+		for i, n := range nodes {
+			if !strings.HasPrefix(n, prefix) {
+				continue
+			}
+			if idx < 0 {
+				idx = i
+				continue
+			}
+			orphan := JoinPath(e.path, n)
+			log.Error("election path:%s delete orphan candidate node:%s", e.path, orphan)
+			if err := e.cli.DeleteNode(orphan); err != nil && err != zk.ErrNoNode {
+				return nil, err
+			}
+		}
+		if idx < 0 {
+			// candidate node gone with expired session, register again
+			node, err := e.cli.createProtected(e.path, candidatePrefix, e.id, e.meta, false)
+			if err != nil {
+				return nil, err
+			}
+			log.Info("election path:%s recreate candidate node:%s", e.path, node)
+			e.node = node
+			continue
+		}
+		e.node = JoinPath(e.path, nodes[idx])
+
+		watched := e.node
+		if idx > 0 {
+			watched = JoinPath(e.path, nodes[idx-1])
+		}
+		exist, ev, err := e.cli.existsW(watched)
+		if err != nil {
+			return nil, err
+		}
+		if !exist {
+			continue
+		}
+		e.setLeader(idx == 0)
+		return ev, nil
+	}
+}
@@ -0,0 +1,128 @@
+package zk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaderElector(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	electionPath := "/test/election"
+	defer cli.DeleteNode(electionPath)
+
+	e1 := NewLeaderElector(cli, electionPath, []byte("candidate1"))
+	e2 := NewLeaderElector(cli, electionPath, []byte("candidate2"))
+	defer e2.Close()
+	if err := e1.Run(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if !<-e1.Leadership() || !e1.IsLeader() {
+		t.FailNow()
+	}
+	if err := e2.Run(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if e2.Run() != errElectorRunning {
+		t.Fail()
+	}
+	time.Sleep(time.Second)
+	if e2.IsLeader() {
+		t.FailNow()
+	}
+	meta, err := e2.Leader()
+	if err != nil || string(meta) != "candidate1" {
+		t.Log(string(meta), err)
+		t.FailNow()
+	}
+
+	e1.Close()
+	select {
+	case leader := <-e2.Leadership():
+		if !leader {
+			t.Fail()
+		}
+	case <-time.After(time.Second * 5):
+		t.FailNow()
+	}
+	meta, err = e2.Leader()
+	if err != nil || string(meta) != "candidate2" {
+		t.Log(string(meta), err)
+		t.Fail()
+	}
+}
+
+func TestLeaderElectorOrphan(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	electionPath := "/test/election-orphan"
+	defer cli.DeleteNode(electionPath)
+
+	// nodes left by lost create responses, the first is reused and others are deleted
+	e := NewLeaderElector(cli, electionPath, []byte("candidate"))
+	defer e.Close()
+	var left []string
+	for i := 0; i < 2; i++ {
+		node, err := cli.CreateWithData(JoinPath(electionPath, protectedName(e.id, candidatePrefix)),
+			[]byte("candidate"), ModeEphemeralSequential)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		left = append(left, node)
+	}
+	if err := e.Run(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if !<-e.Leadership() {
+		t.FailNow()
+	}
+	children, _ := cli.GetChildren(electionPath)
+	if len(children) != 1 || JoinPath(electionPath, children[0]) != left[0] {
+		t.Log(children, left)
+		t.Fail()
+	}
+}
+
+func TestLeaderElectorSessionExpire(t *testing.T) {
+	if testSrv == nil {
+		t.Skip("session expiry needs in-memory server")
+	}
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	electionPath := "/test/election-expire"
+	defer cli.DeleteNode(electionPath)
+
+	e := NewLeaderElector(cli, electionPath, []byte("candidate"))
+	defer e.Close()
+	if err := e.Run(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if !<-e.Leadership() {
+		t.FailNow()
+	}
+
+	// leadership is given up on expiry and gained again with recreated candidate node
+	testSrv.ExpireAllSessions()
+	for _, expect := range []bool{false, true} {
+		select {
+		case leader := <-e.Leadership():
+			if leader != expect {
+				t.Log(leader, expect)
+				t.FailNow()
+			}
+		case <-time.After(time.Second * 5):
+			t.Log("wait leadership timeout", expect)
+			t.FailNow()
+		}
+	}
+	meta, err := e.Leader()
+	if err != nil || string(meta) != "candidate" {
+		t.Log(string(meta), err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,311 @@
+package zk
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/alecthomas/log4go"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// distributed lock on ephemeral sequential nodes under lock dir.
+// the node with smallest sequence hold the lock, others only watch their predecessor,
+// so that deleting one node wakes up one waiter only.
+// shared(read) node only waits for the nearest write node before it.
+// nodes are protected by guid of lock object, see createProtected.
+// lock object is not reentrant and should not be shared between goroutines.
+
+const (
+	lockPrefix  = "lock-"
+	readPrefix  = "read-"
+	writePrefix = "write-"
+	sequenceLen = 10
+)
+
+var (
+	ErrDeadlock  = errors.New("zk lock already held")
+	ErrNotLocked = errors.New("zk lock not held")
+	ErrLockLost  = errors.New("zk lock lost")
+)
+
+type lockNode struct {
+	cli     *ZKClient
+	dir     string
+	prefix  string
+	shared  bool
+	id      string // protected node guid
+	pending bool   // node of id may be left by last lock call
+	mutex   *sync.Mutex
+	node    string
+	lost    chan struct{}
+	release chan struct{}
+}
+
+func newLockNode(cli *ZKClient, dir, prefix string, shared bool) *lockNode {
+	return &lockNode{
+		cli:    cli,
+		dir:    JoinPath(dir),
+		prefix: prefix,
+		shared: shared,
+		id:     newProtectedID(),
+		mutex:  &sync.Mutex{},
+	}
+}
+
+func parseSequence(name string) (int64, bool) {
+	if len(name) < sequenceLen {
+		return 0, false
+	}
+	seq, err := strconv.ParseInt(name[len(name)-sequenceLen:], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return seq, true
+}
+
+// sort lock nodes by sequence, skip nodes without sequence suffix
+func sortBySequence(children []string) []string {
+	nodes := make([]string, 0, len(children))
+	seqs := make(map[string]int64, len(children))
+	for _, child := range children {
+		if seq, ok := parseSequence(child); ok {
+			nodes = append(nodes, child)
+			seqs[child] = seq
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return seqs[nodes[i]] < seqs[nodes[j]]
+	})
+	return nodes
+}
+
+// return node which blocks name from getting the lock, "" means lock acquired
+func findBlocker(children []string, name string, shared bool) (string, error) {
+	nodes := sortBySequence(children)
+	idx := -1
+	for i, n := range nodes {
+		if n == name {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return "", ErrLockLost
+	}
+	for i := idx - 1; i >= 0; i-- {
+		if !shared || !strings.HasPrefix(stripProtected(nodes[i]), readPrefix) {
+			return nodes[i], nil
+		}
+	}
+	return "", nil
+}
+
+func (l *lockNode) lock(ctx context.Context, wait bool) (bool, error) {
+	l.mutex.Lock()
+	held := l.node != ""
+	l.mutex.Unlock()
+	if held {
+		return false, ErrDeadlock
+	}
+
+	node, err := l.cli.createProtected(l.dir, l.prefix, l.id, nil, l.pending)
+	l.pending = err == zk.ErrConnectionClosed
+	if err != nil {
+		return false, err
+	}
+	_, name := SplitPath(node)
+	for {
+		children, err := l.cli.GetChildren(l.dir)
+		if err != nil {
+			l.abandon(node)
+			return false, err
+		}
+		blocker, err := findBlocker(children, name, l.shared)
+		if err != nil {
+			return false, err
+		}
+		if blocker == "" {
+			l.hold(node)
+			return true, nil
+		}
+		if !wait {
+			l.abandon(node)
+			return false, nil
+		}
+
+		exist, ev, err := l.cli.existsW(JoinPath(l.dir, blocker))
+		if err != nil {
+			l.abandon(node)
+			return false, err
+		}
+		if !exist {
+			continue
+		}
+		select {
+		case <-ev:
+		case <-ctx.Done():
+			l.abandon(node)
+			return false, ctx.Err()
+		}
+	}
+}
+
+// delete node not holding lock, node left is reused by next lock call
+func (l *lockNode) abandon(node string) {
+	if err := l.cli.DeleteNode(node); err != nil && err != zk.ErrNoNode {
+		log.Error("delete lock node:%s error:%v", node, err)
+		l.pending = true
+	}
+}
+
+// record lock node and watch it, lost channel is closed once node disappear
+func (l *lockNode) hold(node string) {
+	lost := make(chan struct{})
+	release := make(chan struct{})
+	l.mutex.Lock()
+	l.node = node
+	l.lost = lost
+	l.release = release
+	l.mutex.Unlock()
+
+	go func() {
+		for {
+			exist, ev, err := l.cli.existsW(node)
+			if err != nil || !exist {
+				log.Error("watch lock node:%s fail, exist:%t error:%v", node, exist, err)
+				break
+			}
+			select {
+			case e := <-ev:
+				if e.Type == zk.EventNodeDataChanged {
+					continue
+				}
+				log.Error("lock node:%s recv event:%s", node, e)
+			case <-release:
+				return
+			}
+			break
+		}
+		select {
+		case <-release:
+		default:
+			close(lost)
+		}
+	}()
+}
+
+func (l *lockNode) unlock() error {
+	l.mutex.Lock()
+	node, lost, release := l.node, l.lost, l.release
+	l.node = ""
+	l.mutex.Unlock()
+	if node == "" {
+		return ErrNotLocked
+	}
+	close(release)
+
+	select {
+	case <-lost:
+		return ErrLockLost
+	default:
+	}
+	err := l.cli.DeleteNode(node)
+	if err == zk.ErrNoNode {
+		return ErrLockLost
+	}
+	return err
+}
+
+func (l *lockNode) lostChan() <-chan struct{} {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.node == "" {
+		return nil
+	}
+	return l.lost
+}
+
+// exclusive lock
+type Mutex struct {
+	l *lockNode
+}
+
+func NewMutex(cli *ZKClient, dir string) *Mutex {
+	return &Mutex{
+		l: newLockNode(cli, dir, lockPrefix, false),
+	}
+}
+
+// block until lock acquired or ctx done
+func (m *Mutex) Lock(ctx context.Context) error {
+	_, err := m.l.lock(ctx, true)
+	return err
+}
+
+// return false if lock is held by others
+func (m *Mutex) TryLock() (bool, error) {
+	return m.l.lock(context.Background(), false)
+}
+
+// return ErrLockLost if lock had been lost before unlock
+func (m *Mutex) Unlock() error {
+	return m.l.unlock()
+}
+
+// closed when lock is lost, usually caused by session expired
+// return nil if lock is not held
+func (m *Mutex) Lost() <-chan struct{} {
+	return m.l.lostChan()
+}
+
+// shared/exclusive lock, read lock could be held by many owners at same time
+type RWMutex struct {
+	r *lockNode
+	w *lockNode
+}
+
+func NewRWMutex(cli *ZKClient, dir string) *RWMutex {
+	return &RWMutex{
+		r: newLockNode(cli, dir, readPrefix, true),
+		w: newLockNode(cli, dir, writePrefix, false),
+	}
+}
+
+func (rw *RWMutex) RLock(ctx context.Context) error {
+	_, err := rw.r.lock(ctx, true)
+	return err
+}
+
+func (rw *RWMutex) TryRLock() (bool, error) {
+	return rw.r.lock(context.Background(), false)
+}
+
+func (rw *RWMutex) RUnlock() error {
+	return rw.r.unlock()
+}
+
+func (rw *RWMutex) RLost() <-chan struct{} {
+	return rw.r.lostChan()
+}
+
+func (rw *RWMutex) Lock(ctx context.Context) error {
+	_, err := rw.w.lock(ctx, true)
+	return err
+}
+
+func (rw *RWMutex) TryLock() (bool, error) {
+	return rw.w.lock(context.Background(), false)
+}
+
+func (rw *RWMutex) Unlock() error {
+	return rw.w.unlock()
+}
+
+func (rw *RWMutex) Lost() <-chan struct{} {
+	return rw.w.lostChan()
+}
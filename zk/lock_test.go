@@ -0,0 +1,204 @@
+package zk
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFindBlocker(t *testing.T) {
+	children := []string{"write-0000000003", "read-0000000001", "read-0000000004",
+		"lock-0000000002", "read-0000000005", "invalid"}
+	cases := []struct {
+		name    string
+		shared  bool
+		blocker string
+	}{
+		{"read-0000000001", true, ""},
+		{"lock-0000000002", false, "read-0000000001"},
+		{"write-0000000003", false, "lock-0000000002"},
+		{"read-0000000004", true, "write-0000000003"},
+		{"read-0000000005", true, "write-0000000003"},
+	}
+	for _, c := range cases {
+		blocker, err := findBlocker(children, c.name, c.shared)
+		if err != nil || blocker != c.blocker {
+			t.Log(c.name, blocker, err)
+			t.Fail()
+		}
+	}
+	_, err := findBlocker(children, "read-0000000006", true)
+	if err != ErrLockLost {
+		t.Fail()
+	}
+
+	// protected nodes are ordered by sequence and typed by name after guid
+	children = []string{protectedName("b", "write-0000000002"), protectedName("a", "read-0000000001"),
+		protectedName("c", "read-0000000003")}
+	blocker, err := findBlocker(children, children[2], true)
+	if err != nil || blocker != children[0] {
+		t.Log(blocker, err)
+		t.Fail()
+	}
+}
+
+func TestMutexReuseNode(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	dir := "/test/lockreuse"
+	defer cli.DeleteNode(dir)
+
+	// node left by last lock call whose create response was lost
+	m := NewMutex(cli, dir)
+	left, err := cli.CreateWithData(JoinPath(dir, protectedName(m.l.id, lockPrefix)), nil, ModeEphemeralSequential)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	m.l.pending = true
+	if err := m.Lock(context.Background()); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	children, _ := cli.GetChildren(dir)
+	if len(children) != 1 || JoinPath(dir, children[0]) != left {
+		t.Log(children, left)
+		t.Fail()
+	}
+	if err := m.Unlock(); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+}
+
+func TestMutex(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	dir := "/test/lock"
+	defer cli.DeleteNode(dir)
+
+	m1 := NewMutex(cli, dir)
+	m2 := NewMutex(cli, dir)
+	err := m1.Lock(context.Background())
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if m1.Lock(context.Background()) != ErrDeadlock {
+		t.Fail()
+	}
+	ok, err := m2.TryLock()
+	if err != nil || ok {
+		t.Log(ok, err)
+		t.FailNow()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+	defer cancel()
+	if m2.Lock(ctx) != context.DeadlineExceeded {
+		t.FailNow()
+	}
+
+	locked := make(chan error)
+	go func() {
+		locked <- m2.Lock(context.Background())
+	}()
+	time.Sleep(time.Millisecond * 200)
+	err = m1.Unlock()
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err = <-locked; err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	select {
+	case <-m2.Lost():
+		t.Fail()
+	default:
+	}
+	if m2.Unlock() != nil || m2.Unlock() != ErrNotLocked {
+		t.Fail()
+	}
+}
+
+func TestRWMutex(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	dir := "/test/rwlock"
+	defer cli.DeleteNode(dir)
+
+	rw1 := NewRWMutex(cli, dir)
+	rw2 := NewRWMutex(cli, dir)
+	if err := rw1.RLock(context.Background()); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	ok, err := rw2.TryRLock()
+	if err != nil || !ok {
+		t.Log(ok, err)
+		t.FailNow()
+	}
+	ok, err = rw2.TryLock()
+	if err != nil || ok {
+		t.Log(ok, err)
+		t.FailNow()
+	}
+	rw1.RUnlock()
+	rw2.RUnlock()
+	ok, err = rw2.TryLock()
+	if err != nil || !ok {
+		t.Log(ok, err)
+		t.FailNow()
+	}
+	rw2.Unlock()
+}
+
+func TestMutexSessionExpire(t *testing.T) {
+	if testSrv == nil {
+		t.Skip("session expiry needs in-memory server")
+	}
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	dir := "/test/lock-expire"
+	defer cli.DeleteNode(dir)
+
+	m1 := NewMutex(cli, dir)
+	if err := m1.Lock(context.Background()); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	states := cli.WatchSessionState()
+	defer states.Close()
+
+	testSrv.ExpireAllSessions()
+	select {
+	case <-m1.Lost():
+	case <-time.After(time.Second * 5):
+		t.Log("lock not lost on session expire")
+		t.FailNow()
+	}
+	for ev := range states.States {
+		if ev.State == StateHasSession {
+			break
+		}
+	}
+
+	// lock node is gone with expired session, so others could get the lock
+	m2 := NewMutex(cli, dir)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := m2.Lock(ctx); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := m1.Unlock(); err != ErrLockLost {
+		t.Log(err)
+		t.Fail()
+	}
+	if err := m2.Unlock(); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+}
@@ -1,6 +1,7 @@
 package zk
 
 import (
+	"reflect"
 	"sync"
 	"sync/atomic"
 
@@ -26,11 +27,18 @@ type AddrMonitor interface {
 	Close()
 }
 
+// optional interface of AddrMonitor which could report meta of address
+type MetaAddrMonitor interface {
+	AddrMonitor
+	ValidAddrWithMeta() (<-chan []ServiceAddr, error)
+}
+
 type addrMonitor struct {
 	closed  bool
 	cli     *ZKClient
 	mpath   string
 	watcher []*DirWatcher
+	trees   []*TreeCache
 }
 
 func newAddrMonitor(cli *ZKClient, monitorPath string) *addrMonitor {
@@ -38,6 +46,7 @@ func newAddrMonitor(cli *ZKClient, monitorPath string) *addrMonitor {
 		cli:     cli,
 		mpath:   monitorPath,
 		watcher: make([]*DirWatcher, 0),
+		trees:   make([]*TreeCache, 0),
 	}
 }
 
@@ -52,6 +61,43 @@ func (m *addrMonitor) ValidAddr() (<-chan []string, error) {
 	return w.Children, nil
 }
 
+// watch node data of address by tree cache, send all address after any address changed
+func (m *addrMonitor) ValidAddrWithMeta() (<-chan []ServiceAddr, error) {
+	c := NewTreeCache(m.cli, m.mpath)
+	m.trees = append(m.trees, c)
+	ch := make(chan []ServiceAddr)
+	go func() {
+		defer close(ch)
+		initialized := false
+		for e := range c.Events {
+			if e.Type == TreeInitialized {
+				initialized = true
+			} else if parent, _ := SplitPath(e.Path); e.Path != c.root && parent != c.root {
+				continue
+			}
+			if initialized {
+				ch <- cachedServiceAddrs(c)
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func cachedServiceAddrs(c *TreeCache) []ServiceAddr {
+	children, _ := c.Children(c.root)
+	addrs := make([]ServiceAddr, 0, len(children))
+	for _, child := range children {
+		data, _ := c.Get(JoinPath(c.root, child))
+		meta, err := ParseServiceMeta(data)
+		if err != nil {
+			log.Debug("parse meta of addr:%s error:%v", child, err)
+			meta = nil
+		}
+		addrs = append(addrs, ServiceAddr{Addr: child, Meta: meta})
+	}
+	return addrs
+}
+
 func (m *addrMonitor) Close() {
 	if m.closed {
 		return
@@ -60,6 +106,9 @@ func (m *addrMonitor) Close() {
 	for _, w := range m.watcher {
 		w.Close()
 	}
+	for _, c := range m.trees {
+		c.Close()
+	}
 	m.cli.Close()
 }
 
@@ -68,35 +117,58 @@ const (
 	validMark   = ^invalidMark
 )
 
-type monitorServCli struct {
+// weight of address without meta or with non-positive weight
+const defaultWeight = 1
+
+// state shared by copies of monitorServCli, so that getters using copy in servCliInUse
+// could see cli disabled by health check at once
+type servCliState struct {
 	valid        int32
-	cli          ServiceCli
-	addr         string
+	allocCount   int32
+	inflight     int32
 	enableCount  int
 	disableCount int
-	allocCount   int32
+	health       servCliHealth // guarded by ZKMonitor.servLock
+	notifyLock   *sync.Mutex
+	notified     bool // valid state last notified by OnEnable/OnDisable
+}
+
+type monitorServCli struct {
+	cli   ServiceCli
+	addr  string
+	meta  *ServiceMeta
+	state *servCliState
+}
+
+func newMonitorServCli(cli ServiceCli, addr string, meta *ServiceMeta) monitorServCli {
+	return monitorServCli{
+		cli:   cli,
+		addr:  addr,
+		meta:  meta,
+		state: &servCliState{valid: validMark, notifyLock: &sync.Mutex{}, notified: true},
+	}
 }
 
 func (c *monitorServCli) setValid(valid bool) {
 	if valid {
-		atomic.StoreInt32(&c.valid, validMark)
+		atomic.StoreInt32(&c.state.valid, validMark)
 	} else {
-		atomic.StoreInt32(&c.valid, invalidMark)
+		atomic.StoreInt32(&c.state.valid, invalidMark)
 	}
 }
 
 func (c *monitorServCli) isValid() bool {
-	v := atomic.LoadInt32(&c.valid)
+	v := atomic.LoadInt32(&c.state.valid)
 	return v == validMark
 }
 
+// disable and enable should be called with servLock held, OnDisable and OnEnable are called by notify
 func (c *monitorServCli) disable() {
 	if !c.isValid() {
 		return
 	}
 	c.setValid(false)
-	c.cli.OnDisable()
-	c.disableCount++
+	c.state.disableCount++
 }
 
 func (c *monitorServCli) enable() {
@@ -104,15 +176,86 @@ func (c *monitorServCli) enable() {
 		return
 	}
 	c.setValid(true)
-	c.cli.OnEnable()
-	c.enableCount++
+	c.state.enableCount++
+}
+
+// call OnEnable or OnDisable if valid state differs from the last notified one.
+// called without servLock, so that slow callback would not block others
+func (c *monitorServCli) notify() {
+	c.state.notifyLock.Lock()
+	defer c.state.notifyLock.Unlock()
+	valid := c.isValid()
+	if valid == c.state.notified {
+		return
+	}
+	c.state.notified = valid
+	if valid {
+		c.cli.OnEnable()
+	} else {
+		c.cli.OnDisable()
+	}
+}
+
+// set meta with servLock held, return true if meta changed
+func (c *monitorServCli) setMeta(meta *ServiceMeta) bool {
+	if reflect.DeepEqual(c.meta, meta) {
+		return false
+	}
+	c.meta = meta
+	return true
+}
+
+// call OnMetaUpdate if cli implements MetaServiceCli, called without servLock
+func (c *monitorServCli) notifyMeta() {
+	if mc, ok := c.cli.(MetaServiceCli); ok {
+		mc.OnMetaUpdate(c.meta)
+	}
+}
+
+func (c *monitorServCli) weight() int {
+	if c.meta == nil || c.meta.Weight <= 0 {
+		return defaultWeight
+	}
+	return c.meta.Weight
 }
 
 func (c *monitorServCli) allocConn() ServiceConn {
-	atomic.AddInt32(&c.allocCount, 1)
+	atomic.AddInt32(&c.state.allocCount, 1)
 	return c.cli.GetConn()
 }
 
+// return "" as address if no conn
+func (c *monitorServCli) allocConnAddr() (ServiceConn, string) {
+	conn := c.allocConn()
+	if conn == nil {
+		return nil, ""
+	}
+	return conn, c.addr
+}
+
+// release in-flight count after connection is used
+type ReleaseFunc func()
+
+func noopRelease() {}
+
+// alloc connection and count it as in-flight until release called
+func (c *monitorServCli) acquireConn() (ServiceConn, ReleaseFunc) {
+	state := c.state
+	atomic.AddInt32(&state.inflight, 1)
+	released := int32(0)
+	release := func() {
+		if atomic.CompareAndSwapInt32(&released, 0, 1) {
+			atomic.AddInt32(&state.inflight, -1)
+		}
+	}
+	conn := c.allocConn()
+	if conn == nil {
+		release()
+		return nil, noopRelease
+	}
+	return conn, release
+}
+
 func (c *monitorServCli) close() {
 	c.setValid(false)
 	c.cli.Close()
@@ -121,10 +264,17 @@ func (c *monitorServCli) close() {
 func (c *monitorServCli) String() string {
 	w := bytes.NewBuffer(make([]byte, 0))
 	valid := c.isValid()
-	allocCnt := atomic.LoadInt32(&c.allocCount)
-
-	fmt.Fprintf(w, "{\"valid\":%t,\"addr\":\"%s\",\"enableCnt\":%d,\"disableCnt\":%d,\"allocCnt\":%d",
-		valid, c.addr, c.enableCount, c.disableCount, allocCnt)
+	allocCnt := atomic.LoadInt32(&c.state.allocCount)
+	inflight := atomic.LoadInt32(&c.state.inflight)
+
+	fmt.Fprintf(w, "{\"valid\":%t,\"addr\":\"%s\",\"enableCnt\":%d,\"disableCnt\":%d,\"allocCnt\":%d,\"inflight\":%d",
+		valid, c.addr, c.state.enableCount, c.state.disableCount, allocCnt, inflight)
+	fmt.Fprintf(w, ",\"health\":%s", &c.state.health)
+	if c.meta != nil {
+		if meta, err := c.meta.Marshal(); err == nil {
+			fmt.Fprintf(w, ",\"meta\":%s", meta)
+		}
+	}
 	if valid {
 		fmt.Fprintf(w, ",\"cli\":\"%s\"}", c.cli.Status())
 	} else {
@@ -137,17 +287,39 @@ type ZKMonitor struct {
 	servIdx          map[string]int
 	servCli          []monitorServCli // this array never shrink, set monitorServCli invalid to skip this element
 	servCliInUse     atomic.Value     // store []monitorServCli, copy from servCli
+	inUseGen         uint64           // increase after servCliInUse changed
 	servLock         *sync.RWMutex
 	addrMonitor      AddrMonitor
 	serv             Service
 	servArg          interface{}
 	addrChangedCount int
+	healthOpt        HealthCheckOption
+	healthStop       chan struct{}
+	healthExited     chan struct{}
 }
 
 func NewZKMonitor(zkServers []string, timeout time.Duration, serv Service, servArg interface{},
 	monitorPath string) *ZKMonitor {
 	zkCli := NewZKClient(zkServers, timeout, nil)
 	addrM := newAddrMonitor(zkCli, monitorPath)
+	return NewMonitor(addrM, serv, servArg)
+}
+
+// monitor path readable only with auth credentials
+func NewZKMonitorWithACL(zkServers []string, timeout time.Duration, serv Service, servArg interface{},
+	monitorPath string, opt ACLOption) (*ZKMonitor, error) {
+	zkCli := NewZKClient(zkServers, timeout, nil)
+	// client not connected, auth is only saved
+	if err := zkCli.SetACLOption(opt); err != nil {
+		return nil, err
+	}
+	addrM := newAddrMonitor(zkCli, monitorPath)
+	return NewMonitor(addrM, serv, servArg), nil
+}
+
+// create monitor with any address monitor, such as StaticAddrMonitor, FileAddrMonitor
+// or DNSAddrMonitor, so that getters could work without zookeeper
+func NewMonitor(addrM AddrMonitor, serv Service, servArg interface{}) *ZKMonitor {
 	zkm := &ZKMonitor{
 		servIdx:      make(map[string]int),
 		servCli:      make([]monitorServCli, 0),
@@ -162,7 +334,7 @@ func NewZKMonitor(zkServers []string, timeout time.Duration, serv Service, servA
 }
 
 func (zkm *ZKMonitor) Run() {
-	validAddr, err := zkm.addrMonitor.ValidAddr()
+	validAddr, err := zkm.validServiceAddr()
 	if err != nil {
 		log.Error("run zk monitor failed, error:%v", err)
 		return
@@ -173,15 +345,54 @@ func (zkm *ZKMonitor) Run() {
 	go func() {
 		close(sched)
 		for addrs := range validAddr {
-			zkm.onAddrChange(addrs)
+			zkm.onServiceChange(addrs)
+			zkm.servLock.Lock()
 			zkm.addrChangedCount++
+			zkm.servLock.Unlock()
 		}
 		log.Debug("zk monitor stopped")
 	}()
 	<-sched
+
+	if zkm.healthOpt.Interval > 0 || zkm.healthOpt.EjectThreshold > 0 {
+		zkm.healthStop = make(chan struct{})
+		zkm.healthExited = make(chan struct{})
+		go zkm.healthLoop()
+	}
+}
+
+// use meta address if addrMonitor support, otherwise address without meta
+func (zkm *ZKMonitor) validServiceAddr() (<-chan []ServiceAddr, error) {
+	if mm, ok := zkm.addrMonitor.(MetaAddrMonitor); ok {
+		return mm.ValidAddrWithMeta()
+	}
+	validAddr, err := zkm.addrMonitor.ValidAddr()
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []ServiceAddr)
+	go func() {
+		defer close(ch)
+		for addrs := range validAddr {
+			ch <- toServiceAddrs(addrs)
+		}
+	}()
+	return ch, nil
+}
+
+func toServiceAddrs(addrs []string) []ServiceAddr {
+	servAddrs := make([]ServiceAddr, 0, len(addrs))
+	for _, addr := range addrs {
+		servAddrs = append(servAddrs, ServiceAddr{Addr: addr})
+	}
+	return servAddrs
 }
 
 func (zkm *ZKMonitor) Close() {
+	if zkm.healthStop != nil {
+		close(zkm.healthStop)
+		<-zkm.healthExited
+	}
 	zkm.addrMonitor.Close()
 	zkm.servLock.Lock()
 	for _, cli := range zkm.servCli {
@@ -190,47 +401,77 @@ func (zkm *ZKMonitor) Close() {
 	zkm.servLock.Unlock()
 }
 
-// enable/disable service cli, add new service cli
 func (zkm *ZKMonitor) onAddrChange(addrs []string) {
+	zkm.onServiceChange(toServiceAddrs(addrs))
+}
+
+// enable/disable service cli, add new service cli, update meta of exist service cli
+// cli of exist address is enabled only if it is healthy
+func (zkm *ZKMonitor) onServiceChange(addrs []ServiceAddr) {
+	// InitCli of new address may dial, so it is called without servLock.
+	// onServiceChange is the only one adding address, new address could not be added meanwhile
+	zkm.servLock.RLock()
+	var added []ServiceAddr
 	addrMark := make(map[string]bool)
-	var added []string
+	for _, addr := range addrs {
+		if _, ok := zkm.servIdx[addr.Addr]; !ok && !addrMark[addr.Addr] {
+			added = append(added, addr)
+		}
+		addrMark[addr.Addr] = true
+	}
+	zkm.servLock.RUnlock()
+	newCli := make(map[string]monitorServCli, len(added))
+	for _, sa := range added {
+		newCli[sa.Addr] = newMonitorServCli(zkm.initCli(sa.Addr, sa.Meta), sa.Addr, sa.Meta)
+	}
 
+	var metaUpdated []monitorServCli
+	zkm.servLock.Lock()
 	clients := make([]monitorServCli, 0, len(addrs))
 	for _, addr := range addrs {
-		if idx, ok := zkm.servIdx[addr]; !ok {
-			added = append(added, addr)
-		} else {
-			zkm.servCli[idx].enable()
-			clients = append(clients, zkm.servCli[idx])
+		if !addrMark[addr.Addr] {
+			continue
 		}
-		addrMark[addr] = true
+		// skip duplicate address
+		addrMark[addr.Addr] = false
+		if c, ok := newCli[addr.Addr]; ok {
+			zkm.servIdx[addr.Addr] = len(zkm.servCli)
+			zkm.servCli = append(zkm.servCli, c)
+			clients = append(clients, c)
+			continue
+		}
+		c := &zkm.servCli[zkm.servIdx[addr.Addr]]
+		c.state.health.removed = false
+		zkm.refreshValid(c)
+		if c.setMeta(addr.Meta) {
+			metaUpdated = append(metaUpdated, *c)
+		}
+		clients = append(clients, *c)
 	}
 	for addr, i := range zkm.servIdx {
 		if _, ok := addrMark[addr]; !ok {
+			zkm.servCli[i].state.health.removed = true
 			zkm.servCli[i].disable()
 		}
 	}
-	if len(added) > 0 {
-		n := len(zkm.servCli)
-		for _, addr := range added {
-			cli := zkm.serv.InitCli(addr, zkm.servArg)
-
-			servCli := monitorServCli{
-				cli:   cli,
-				valid: validMark,
-				addr:  addr,
-			}
-			zkm.servLock.Lock()
-			zkm.servCli = append(zkm.servCli, servCli)
-			zkm.servLock.Unlock()
+	all := append([]monitorServCli(nil), zkm.servCli...)
+	zkm.servCliInUse.Store(clients)
+	atomic.AddUint64(&zkm.inUseGen, 1)
+	zkm.servLock.Unlock()
 
-			clients = append(clients, servCli)
+	for i := range all {
+		all[i].notify()
+	}
+	for i := range metaUpdated {
+		metaUpdated[i].notifyMeta()
+	}
+}
 
-			zkm.servIdx[addr] = n
-			n++
-		}
+func (zkm *ZKMonitor) initCli(addr string, meta *ServiceMeta) ServiceCli {
+	if ms, ok := zkm.serv.(MetaService); ok {
+		return ms.InitCliWithMeta(addr, meta, zkm.servArg)
 	}
-	zkm.servCliInUse.Store(clients)
+	return zkm.serv.InitCli(addr, zkm.servArg)
 }
 
 func (zkm *ZKMonitor) validServClients() []monitorServCli {
@@ -272,11 +513,17 @@ type RoundTripGetter struct {
 
 // find first valid cli and GetConn by roundtrip
 func (rtg *RoundTripGetter) GetConn() ServiceConn {
+	conn, _ := rtg.GetConnAddr()
+	return conn
+}
+
+// same as GetConn, also return address of conn for ZKMonitor.ReportFailure and ReportSuccess
+func (rtg *RoundTripGetter) GetConnAddr() (ServiceConn, string) {
 	zkm := rtg.zkm
 	servCli := zkm.validServClients()
 	availAddr := len(servCli)
 	if availAddr == 0 {
-		return nil
+		return nil, ""
 	}
 
 	n := atomic.AddUint32(&rtg.nextUse, uint32(1))
@@ -284,9 +531,9 @@ func (rtg *RoundTripGetter) GetConn() ServiceConn {
 
 	ok, idx := findFirstValid(servCli, int(cur))
 	if ok {
-		return servCli[idx].allocConn()
+		return servCli[idx].allocConnAddr()
 	}
-	return nil
+	return nil, ""
 }
 
 // default use fnv-1a hash func
@@ -311,13 +558,19 @@ type HashGetter struct {
 
 // find first valid cli and GetConn by hash
 func (hg *HashGetter) GetConn(key []byte) ServiceConn {
+	conn, _ := hg.GetConnAddr(key)
+	return conn
+}
+
+// same as GetConn, also return address of conn for ZKMonitor.ReportFailure and ReportSuccess
+func (hg *HashGetter) GetConnAddr(key []byte) (ServiceConn, string) {
 	hashVal := hg.hashFn(key)
 	zkm := hg.zkm
 
 	servCli := zkm.validServClients()
 	availAddr := int32(len(servCli))
 	if availAddr == 0 {
-		return nil
+		return nil, ""
 	}
 
 	cur := int32(0)
@@ -330,13 +583,139 @@ func (hg *HashGetter) GetConn(key []byte) ServiceConn {
 
 	ok, idx := findFirstValid(servCli, int(cur))
 	if ok {
-		return servCli[idx].allocConn()
+		return servCli[idx].allocConnAddr()
+	}
+	return nil, ""
+}
+
+func (zkm *ZKMonitor) WeightedGetter() *WeightedGetter {
+	return &WeightedGetter{
+		zkm:     zkm,
+		lock:    &sync.Mutex{},
+		current: make(map[string]int),
+	}
+}
+
+// smooth weighted round robin by meta weight, same as nginx upstream
+type WeightedGetter struct {
+	zkm     *ZKMonitor
+	lock    *sync.Mutex
+	gen     uint64         // inUseGen of last prune
+	current map[string]int // addr -> current weight
+}
+
+// choose valid cli with max current weight, then decrease its current weight by total weight
+func (wg *WeightedGetter) GetConn() ServiceConn {
+	conn, _ := wg.GetConnAddr()
+	return conn
+}
+
+// same as GetConn, also return address of conn for ZKMonitor.ReportFailure and ReportSuccess
+func (wg *WeightedGetter) GetConnAddr() (ServiceConn, string) {
+	// load gen before cli, so that cli is not older than gen
+	gen := atomic.LoadUint64(&wg.zkm.inUseGen)
+	servCli := wg.zkm.validServClients()
+
+	wg.lock.Lock()
+	if wg.gen != gen {
+		wg.prune(servCli)
+		wg.gen = gen
+	}
+	best := -1
+	total := 0
+	for i := range servCli {
+		if !servCli[i].isValid() {
+			continue
+		}
+		w := servCli[i].weight()
+		addr := servCli[i].addr
+		wg.current[addr] += w
+		total += w
+		if best < 0 || wg.current[addr] > wg.current[servCli[best].addr] {
+			best = i
+		}
+	}
+	if best < 0 {
+		wg.lock.Unlock()
+		return nil, ""
+	}
+	wg.current[servCli[best].addr] -= total
+	wg.lock.Unlock()
+
+	return servCli[best].allocConnAddr()
+}
+
+// drop current weight of address no longer in use
+func (wg *WeightedGetter) prune(servCli []monitorServCli) {
+	inUse := make(map[string]bool, len(servCli))
+	for i := range servCli {
+		inUse[servCli[i].addr] = true
+	}
+	for addr := range wg.current {
+		if !inUse[addr] {
+			delete(wg.current, addr)
+		}
+	}
+}
+
+func (zkm *ZKMonitor) LeastConnGetter() *LeastConnGetter {
+	return &LeastConnGetter{
+		zkm: zkm,
+	}
+}
+
+// choose valid cli with least in-flight connection per weight
+type LeastConnGetter struct {
+	zkm     *ZKMonitor
+	nextUse uint32
+}
+
+// call ReleaseFunc after connection used, it is safe to call more than once
+func (lg *LeastConnGetter) GetConn() (ServiceConn, ReleaseFunc) {
+	conn, _, release := lg.GetConnAddr()
+	return conn, release
+}
+
+// same as GetConn, also return address of conn for ZKMonitor.ReportFailure and ReportSuccess
+func (lg *LeastConnGetter) GetConnAddr() (ServiceConn, string, ReleaseFunc) {
+	servCli := lg.zkm.validServClients()
+	sz := len(servCli)
+	if sz == 0 {
+		return nil, "", noopRelease
+	}
+
+	// start from different cli to break tie
+	start := int(atomic.AddUint32(&lg.nextUse, uint32(1)) % uint32(sz))
+	best := -1
+	var bestLoad, bestWeight int64
+	for k := 0; k < sz; k++ {
+		i := (start + k) % sz
+		if !servCli[i].isValid() {
+			continue
+		}
+		load := int64(atomic.LoadInt32(&servCli[i].state.inflight))
+		w := int64(servCli[i].weight())
+		// load/w < bestLoad/bestWeight
+		if best < 0 || load*bestWeight < bestLoad*w {
+			best = i
+			bestLoad = load
+			bestWeight = w
+		}
+	}
+	if best < 0 {
+		return nil, "", noopRelease
+	}
+	conn, release := servCli[best].acquireConn()
+	if conn == nil {
+		return nil, "", release
 	}
-	return nil
+	return conn, servCli[best].addr, release
 }
 
 func (zkm *ZKMonitor) Status() string {
 	servCli := zkm.validServClients()
+	zkm.servLock.RLock()
+	defer zkm.servLock.RUnlock()
 
 	s := make([]string, 0, len(servCli))
 	for _, cli := range servCli {
@@ -30,6 +30,8 @@ func (t *testService) OnDisable() {}
 func (t *testService) OnEnable()  {}
 
 func checkAddrs(addrs []string, zkm *ZKMonitor, t *testing.T) {
+	zkm.servLock.RLock()
+	defer zkm.servLock.RUnlock()
 	for _, addr := range addrs {
 		idx, ok := zkm.servIdx[addr]
 		if !ok {
@@ -104,6 +106,163 @@ func TestAdjustCli(t *testing.T) {
 	checkAddrs(newAddrs, zkm, t)
 }
 
+type testMetaService struct {
+	testService
+	addr    string
+	meta    *ServiceMeta
+	updated int
+}
+
+func (t *testMetaService) InitCliWithMeta(addr string, meta *ServiceMeta, arg interface{}) ServiceCli {
+	return &testMetaService{addr: addr, meta: meta}
+}
+func (t *testMetaService) OnMetaUpdate(meta *ServiceMeta) {
+	t.meta = meta
+	t.updated++
+}
+
+func TestServiceMetaChange(t *testing.T) {
+	zkm := &ZKMonitor{
+		servIdx:     make(map[string]int),
+		servCli:     make([]monitorServCli, 0),
+		servLock:    &sync.RWMutex{},
+		addrMonitor: nil,
+		serv:        &testMetaService{},
+		servArg:     nil,
+	}
+	addrs := []ServiceAddr{
+		{"addr1", &ServiceMeta{Weight: 1}},
+		{"addr2", nil},
+	}
+	zkm.onServiceChange(addrs)
+	t.Log(zkm.Status())
+	cli := zkm.servCli[zkm.servIdx["addr1"]].cli.(*testMetaService)
+	if cli.addr != "addr1" || cli.meta.Weight != 1 {
+		t.FailNow()
+	}
+
+	zkm.onServiceChange([]ServiceAddr{
+		{"addr1", &ServiceMeta{Weight: 1}},
+		{"addr2", nil},
+	})
+	if cli.updated != 0 {
+		t.FailNow()
+	}
+	zkm.onServiceChange([]ServiceAddr{
+		{"addr1", &ServiceMeta{Weight: 2}},
+	})
+	t.Log(zkm.Status())
+	if cli.updated != 1 || cli.meta.Weight != 2 {
+		t.FailNow()
+	}
+	checkAddrs([]string{"addr1"}, zkm, t)
+}
+
+type testAddrService struct {
+	testService
+	addr string
+}
+
+func (t *testAddrService) InitCli(addr string, arg interface{}) ServiceCli {
+	return &testAddrService{addr: addr}
+}
+func (t *testAddrService) GetConn() ServiceConn {
+	return t.addr
+}
+
+func newTestMonitor(serv Service) *ZKMonitor {
+	zkm := &ZKMonitor{
+		servIdx:  make(map[string]int),
+		servCli:  make([]monitorServCli, 0),
+		servLock: &sync.RWMutex{},
+		serv:     serv,
+	}
+	zkm.servCliInUse.Store(make([]monitorServCli, 0))
+	return zkm
+}
+
+func TestWeightedGet(t *testing.T) {
+	zkm := newTestMonitor(&testAddrService{})
+	if zkm.WeightedGetter().GetConn() != nil {
+		t.FailNow()
+	}
+	zkm.onServiceChange([]ServiceAddr{
+		{"a", &ServiceMeta{Weight: 5}},
+		{"b", &ServiceMeta{Weight: 1}},
+		{"c", nil},
+	})
+	wg := zkm.WeightedGetter()
+	trace := ""
+	for i := 0; i < 7; i++ {
+		trace += wg.GetConn().(string)
+	}
+	if trace != "aabacaa" {
+		t.Log(trace)
+		t.FailNow()
+	}
+
+	zkm.onServiceChange([]ServiceAddr{
+		{"b", &ServiceMeta{Weight: 1}},
+		{"c", &ServiceMeta{Weight: 1}},
+	})
+	cnt := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		cnt[wg.GetConn().(string)]++
+	}
+	if cnt["a"] != 0 || cnt["b"] != 5 || cnt["c"] != 5 {
+		t.Log(cnt)
+		t.Fail()
+	}
+
+	// address replaced while count unchanged
+	zkm.onServiceChange([]ServiceAddr{
+		{"b", &ServiceMeta{Weight: 1}},
+		{"d", &ServiceMeta{Weight: 1}},
+	})
+	wg.GetConn()
+	if _, ok := wg.current["c"]; ok || len(wg.current) != 2 {
+		t.Log(wg.current)
+		t.Fail()
+	}
+}
+
+func TestLeastConnGet(t *testing.T) {
+	zkm := newTestMonitor(&testAddrService{})
+	lg := zkm.LeastConnGetter()
+	conn, release := lg.GetConn()
+	if conn != nil {
+		t.FailNow()
+	}
+	release()
+
+	zkm.onServiceChange([]ServiceAddr{
+		{"a", &ServiceMeta{Weight: 2}},
+		{"b", nil},
+	})
+	releases := make([]ReleaseFunc, 0)
+	cnt := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		conn, release := lg.GetConn()
+		cnt[conn.(string)]++
+		releases = append(releases, release)
+	}
+	if cnt["a"] != 4 || cnt["b"] != 2 {
+		t.Log(cnt)
+		t.FailNow()
+	}
+	for _, release := range releases {
+		release()
+		release()
+	}
+	for i := range zkm.servCli {
+		if zkm.servCli[i].state.inflight != 0 {
+			t.Log(zkm.Status())
+			t.FailNow()
+		}
+	}
+	t.Log(zkm.Status())
+}
+
 func TestZKMonitor(t *testing.T) {
 	mPath := "/test"
 	cli := NewZKClient(testServers, time.Second*5, nil)
@@ -0,0 +1,138 @@
+package zk
+
+import (
+	"errors"
+	"strings"
+)
+
+const pathSeparator = "/"
+
+var (
+	ErrInvalidPath    = errors.New("invalid zk path")
+	ErrChrootConflict = errors.New("zk servers have different chroot")
+)
+
+// check path is absolute, and has no empty, "." or ".." element, no trailing separator except root
+func ValidatePath(npath string) error {
+	if npath == pathSeparator {
+		return nil
+	}
+	if !strings.HasPrefix(npath, pathSeparator) || strings.HasSuffix(npath, pathSeparator) ||
+		strings.ContainsRune(npath, 0) {
+		return ErrInvalidPath
+	}
+	for _, elem := range strings.Split(npath[1:], pathSeparator) {
+		if elem == "" || elem == "." || elem == ".." {
+			return ErrInvalidPath
+		}
+	}
+	return nil
+}
+
+// join elements to absolute path, empty elements and redundant separators are dropped,
+// JoinPath("/a/", "b") and JoinPath("a", "/b/") both return "/a/b"
+func JoinPath(elem ...string) string {
+	parts := make([]string, 0, len(elem))
+	for _, e := range elem {
+		for _, p := range strings.Split(e, pathSeparator) {
+			if p != "" {
+				parts = append(parts, p)
+			}
+		}
+	}
+	return pathSeparator + strings.Join(parts, pathSeparator)
+}
+
+// split path to parent path and node name, SplitPath("/a/b") returns "/a", "b",
+// SplitPath("/") returns "/", ""
+func SplitPath(npath string) (string, string) {
+	idx := strings.LastIndex(npath, pathSeparator)
+	if idx < 0 {
+		return pathSeparator, npath
+	}
+	if idx == 0 {
+		return pathSeparator, npath[1:]
+	}
+	return npath[:idx], npath[idx+1:]
+}
+
+// normalize and validate path, relative path is taken as absolute and redundant separators are removed
+func cleanPath(npath string) (string, error) {
+	npath = JoinPath(npath)
+	if err := ValidatePath(npath); err != nil {
+		return "", err
+	}
+	return npath, nil
+}
+
+// split chroot from server address, such as "127.0.0.1:2181/app"
+func splitChroot(addr string) (string, string) {
+	idx := strings.Index(addr, pathSeparator)
+	if idx < 0 {
+		return addr, ""
+	}
+	return addr[:idx], addr[idx:]
+}
+
+// all paths of client are relative to chroot, should be called before connect.
+// chroot could also be set by server address, such as "127.0.0.1:2181/app"
+func (cli *ZKClient) SetChroot(root string) error {
+	root, err := cleanPath(root)
+	if err != nil {
+		return err
+	}
+	if root == pathSeparator {
+		root = ""
+	}
+	cli.optLock.Lock()
+	cli.chroot = root
+	cli.chrootErr = nil
+	cli.optLock.Unlock()
+	return nil
+}
+
+func (cli *ZKClient) Chroot() string {
+	chroot, _ := cli.getChroot()
+	if chroot == "" {
+		return pathSeparator
+	}
+	return chroot
+}
+
+// chrootErr is set if chroot of server address is invalid or conflicted
+func (cli *ZKClient) getChroot() (string, error) {
+	cli.optLock.RLock()
+	defer cli.optLock.RUnlock()
+	return cli.chroot, cli.chrootErr
+}
+
+// map client path to server path under chroot
+func (cli *ZKClient) fullPath(npath string) (string, error) {
+	chroot, err := cli.getChroot()
+	if err != nil {
+		return "", err
+	}
+	npath, err = cleanPath(npath)
+	if err != nil {
+		return "", err
+	}
+	if chroot == "" {
+		return npath, nil
+	}
+	if npath == pathSeparator {
+		return chroot, nil
+	}
+	return chroot + npath, nil
+}
+
+// map server path to client path
+func (cli *ZKClient) clientPath(npath string) string {
+	chroot, _ := cli.getChroot()
+	if chroot == "" {
+		return npath
+	}
+	if npath == chroot {
+		return pathSeparator
+	}
+	return strings.TrimPrefix(npath, chroot)
+}
@@ -0,0 +1,131 @@
+package zk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidatePath(t *testing.T) {
+	valid := []string{"/", "/a", "/a/b", "/a.b/c-1"}
+	for _, p := range valid {
+		if ValidatePath(p) != nil {
+			t.Log(p)
+			t.Fail()
+		}
+	}
+	invalid := []string{"", "a", "/a/", "//a", "/a//b", "/a/./b", "/a/..", "/a\x00"}
+	for _, p := range invalid {
+		if ValidatePath(p) != ErrInvalidPath {
+			t.Log(p)
+			t.Fail()
+		}
+	}
+}
+
+func TestJoinSplitPath(t *testing.T) {
+	cases := []struct {
+		elem []string
+		path string
+		dir  string
+		name string
+	}{
+		{[]string{"/"}, "/", "/", ""},
+		{[]string{"/a/", "b"}, "/a/b", "/a", "b"},
+		{[]string{"a", "/b/", "c"}, "/a/b/c", "/a/b", "c"},
+		{[]string{"/a", "", "lock-"}, "/a/lock-", "/a", "lock-"},
+		{[]string{"//a"}, "/a", "/", "a"},
+	}
+	for _, c := range cases {
+		p := JoinPath(c.elem...)
+		dir, name := SplitPath(p)
+		if p != c.path || dir != c.dir || name != c.name {
+			t.Log(c.elem, p, dir, name)
+			t.Fail()
+		}
+	}
+}
+
+func TestChroot(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	chrootCli := NewZKClient([]string{testServers[0] + "/test/chroot/"}, time.Second*5, nil)
+	defer chrootCli.Close()
+	if chrootCli.Chroot() != "/test/chroot" || chrootCli.Servers[0] != testServers[0] {
+		t.Log(chrootCli.Chroot(), chrootCli.Servers)
+		t.FailNow()
+	}
+	defer cli.DeleteNode("/test/chroot")
+
+	node, err := chrootCli.CreateWithData("/seq/node-", []byte("data"), ModeEphemeralSequential)
+	if err != nil || node != "/seq/node-0000000000" {
+		t.Log(node, err)
+		t.FailNow()
+	}
+	defer cli.DeleteNode("/test/chroot/seq")
+	data, _, err := cli.GetData("/test/chroot" + node)
+	if err != nil || string(data) != "data" {
+		t.Log(err)
+		t.Fail()
+	}
+	children, err := chrootCli.GetChildren("/")
+	if err != nil || len(children) != 1 || children[0] != "seq" {
+		t.Log(children, err)
+		t.Fail()
+	}
+	if _, err := chrootCli.GetChildren("/seq/../.."); err != ErrInvalidPath {
+		t.Log(err)
+		t.Fail()
+	}
+	if err := chrootCli.DeleteNode(node); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+}
+
+func TestRelativePath(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	if err := cli.CreatePersistNode("test/relative/node"); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer cli.DeleteNode("/test/relative")
+	defer cli.DeleteNode("/test/relative/node")
+	ok, err := cli.NodeExist("/test/relative/node")
+	if err != nil || !ok {
+		t.Log(ok, err)
+		t.Fail()
+	}
+	if err := cli.CreateEphemeralNode("test/relative/ephemeral"); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+	if err := cli.DeleteNode("test/relative/ephemeral"); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+}
+
+func TestChrootConflict(t *testing.T) {
+	cli := NewZKClient([]string{"127.0.0.1:2181/a", "127.0.0.2:2181/b"}, time.Second*5, nil)
+	defer cli.Close()
+	if _, err := cli.fullPath("/node"); err != ErrChrootConflict {
+		t.Log(err)
+		t.Fail()
+	}
+	if err := cli.SetChroot("/b"); err != nil {
+		t.FailNow()
+	}
+	if p, err := cli.fullPath("/node"); err != nil || p != "/b/node" {
+		t.Log(p, err)
+		t.Fail()
+	}
+
+	// chroot suffix of the last server applies to all, as connect string "h1:2181,h2:2181/a"
+	cli = NewZKClient([]string{"127.0.0.1:2181", "127.0.0.2:2181/a/", "127.0.0.3:2181/a"}, time.Second*5, nil)
+	defer cli.Close()
+	if p, err := cli.fullPath("node"); err != nil || p != "/a/node" {
+		t.Log(p, err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,78 @@
+package zk
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// protected ephemeral sequential node is named like "_c_<guid>-lock-0000000001" as Curator does.
+// create may fail with connection loss after server has created the node, then the node is
+// looked up by guid instead of being created again, otherwise it is orphaned until session expires.
+
+const (
+	protectedPrefix = "_c_"
+	protectedRetry  = 3
+)
+
+func newProtectedID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func protectedName(id, prefix string) string {
+	return protectedPrefix + id + "-" + prefix
+}
+
+// node name without protected prefix, such as "lock-0000000001"
+func stripProtected(name string) string {
+	if !strings.HasPrefix(name, protectedPrefix) {
+		return name
+	}
+	if i := strings.IndexByte(name[len(protectedPrefix):], '-'); i >= 0 {
+		return name[len(protectedPrefix)+i+1:]
+	}
+	return name
+}
+
+// create protected node of id under dir, existing node of id is reused if lookup is true,
+// such as after last create failed with connection loss
+func (cli *ZKClient) createProtected(dir, prefix, id string, data []byte, lookup bool) (string, error) {
+	if lookup {
+		node, err := cli.findProtected(dir, id)
+		if err != nil || node != "" {
+			return node, err
+		}
+	}
+	npath := JoinPath(dir, protectedName(id, prefix))
+	node, err := cli.CreateWithData(npath, data, ModeEphemeralSequential)
+	for i := 0; i < protectedRetry && err == zk.ErrConnectionClosed; i++ {
+		// node may be created before response lost
+		node, err = cli.findProtected(dir, id)
+		if err == nil && node == "" {
+			node, err = cli.CreateWithData(npath, data, ModeEphemeralSequential)
+		}
+	}
+	return node, err
+}
+
+// return path of the first protected node of id under dir, "" if not found
+func (cli *ZKClient) findProtected(dir, id string) (string, error) {
+	children, err := cli.GetChildren(dir)
+	if err == zk.ErrNoNode {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	prefix := protectedName(id, "")
+	for _, n := range sortBySequence(children) {
+		if strings.HasPrefix(n, prefix) {
+			return JoinPath(dir, n), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,82 @@
+package zk
+
+import (
+	"bytes"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// connection dropping the first response after a protected create request armed by drop
+type dropConn struct {
+	net.Conn
+	drop *int32 // 1: armed, 2: protected create sent
+}
+
+func (c dropConn) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(c.drop) == 1 && bytes.Contains(b, []byte(protectedPrefix)) {
+		atomic.StoreInt32(c.drop, 2)
+	}
+	return c.Conn.Write(b)
+}
+
+func (c dropConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err == nil && atomic.CompareAndSwapInt32(c.drop, 2, 0) {
+		// server has replied, create is done
+		c.Conn.Close()
+		return 0, net.ErrClosed
+	}
+	return n, err
+}
+
+func TestStripProtected(t *testing.T) {
+	cases := map[string]string{
+		protectedName(newProtectedID(), "lock-0000000001"): "lock-0000000001",
+		"lock-0000000001": "lock-0000000001",
+		"_c_invalid":      "_c_invalid",
+	}
+	for name, expect := range cases {
+		if got := stripProtected(name); got != expect {
+			t.Log(name, got)
+			t.Fail()
+		}
+	}
+}
+
+func TestCreateProtected(t *testing.T) {
+	var drop int32
+	cli := NewZKClient(testServers, time.Second*5, func(network, address string, timeout time.Duration) (net.Conn, error) {
+		c, err := net.DialTimeout(network, address, timeout)
+		if err != nil {
+			return nil, err
+		}
+		return dropConn{Conn: c, drop: &drop}, nil
+	})
+	defer cli.Close()
+	dir := "/test/protected"
+	if err := cli.CreatePersistNode(dir); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer cli.DeleteNode(dir)
+
+	id := newProtectedID()
+	atomic.StoreInt32(&drop, 1)
+	node, err := cli.createProtected(dir, "node-", id, nil, false)
+	if err != nil || atomic.LoadInt32(&drop) != 0 {
+		t.Log(node, err, atomic.LoadInt32(&drop))
+		t.FailNow()
+	}
+	defer cli.DeleteNode(node)
+	children, err := cli.GetChildren(dir)
+	if err != nil || len(children) != 1 || JoinPath(dir, children[0]) != node {
+		t.Log(children, node, err)
+		t.Fail()
+	}
+	if found, err := cli.findProtected(dir, id); err != nil || found != node {
+		t.Log(found, err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,272 @@
+package zk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// distributed queue on persistent sequential nodes under queue dir.
+// item node is named like "item-005-0000000012", items are taken in order of
+// priority then sequence, so items with same priority are FIFO.
+// Take removes item at once, while Claim marks item by an ephemeral node under
+// claim dir, item is removed by Ack or becomes visible again if claim node disappears,
+// such as consumer crashed. consumers of one queue should use only one of the two modes.
+// claiming bumps data version of item node, Ack and Release check the version in transaction,
+// so that consumer which has lost its claim could not remove claim of the new owner.
+// it's for low volume tasks, every dequeue reads all children of queue dir.
+
+const (
+	queueItemPrefix = "item-"
+	queueClaimDir   = "claim"
+	MaxPriority     = 999
+)
+
+var (
+	ErrInvalidPriority = errors.New("zk queue priority out of range")
+	ErrClaimLost       = errors.New("zk queue claim lost")
+)
+
+type Queue struct {
+	cli      *ZKClient
+	dir      string
+	claimDir string
+}
+
+func NewQueue(cli *ZKClient, dir string) *Queue {
+	dir = JoinPath(dir)
+	return &Queue{
+		cli:      cli,
+		dir:      dir,
+		claimDir: JoinPath(dir, queueClaimDir),
+	}
+}
+
+type queueItem struct {
+	name     string
+	priority int
+	seq      int64
+}
+
+// parse "item-005-0000000012", ok is false for other nodes
+func parseQueueItem(name string) (queueItem, bool) {
+	item := queueItem{name: name}
+	if !strings.HasPrefix(name, queueItemPrefix) {
+		return item, false
+	}
+	parts := strings.Split(name[len(queueItemPrefix):], "-")
+	if len(parts) != 2 || len(parts[1]) != sequenceLen {
+		return item, false
+	}
+	priority, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return item, false
+	}
+	seq, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return item, false
+	}
+	item.priority, item.seq = priority, seq
+	return item, true
+}
+
+// return item names in dequeue order
+func sortQueueItems(children []string) []string {
+	items := make([]queueItem, 0, len(children))
+	for _, child := range children {
+		if item, ok := parseQueueItem(child); ok {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].priority != items[j].priority {
+			return items[i].priority < items[j].priority
+		}
+		return items[i].seq < items[j].seq
+	})
+	names := make([]string, len(items))
+	for i := range items {
+		names[i] = items[i].name
+	}
+	return names
+}
+
+// enqueue item with priority 0, return item name
+func (q *Queue) Put(data []byte) (string, error) {
+	return q.PutPriority(data, 0)
+}
+
+// smaller priority is taken first, priority should be in [0, MaxPriority]
+func (q *Queue) PutPriority(data []byte, priority int) (string, error) {
+	if priority < 0 || priority > MaxPriority {
+		return "", ErrInvalidPriority
+	}
+	prefix := fmt.Sprintf("%s%03d-", queueItemPrefix, priority)
+	node, err := q.cli.CreateWithData(JoinPath(q.dir, prefix), data, ModePersistentSequential)
+	if err != nil {
+		return "", err
+	}
+	_, name := SplitPath(node)
+	return name, nil
+}
+
+// number of items, including claimed items
+func (q *Queue) Len() (int, error) {
+	children, err := q.cli.GetChildren(q.dir)
+	if err == zk.ErrNoNode {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(sortQueueItems(children)), nil
+}
+
+// list children of dir and watch, dir is created if not exist
+func (q *Queue) watchChildren(dir string) ([]string, <-chan zk.Event, error) {
+	for {
+		children, ev, err := q.cli.WatchDirOnce(dir)
+		if err == zk.ErrNoNode {
+			if err = q.cli.CreatePersistNode(dir); err != nil && err != zk.ErrNodeExists {
+				return nil, nil, err
+			}
+			continue
+		}
+		return children, ev, err
+	}
+}
+
+// dequeue the first item, block until queue not empty or ctx done
+func (q *Queue) Take(ctx context.Context) ([]byte, error) {
+	for {
+		children, ev, err := q.watchChildren(q.dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range sortQueueItems(children) {
+			npath := JoinPath(q.dir, name)
+			data, _, err := q.cli.GetData(npath)
+			if err == zk.ErrNoNode {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			// item is taken by whom deletes it
+			err = q.cli.DeleteNode(npath)
+			if err == zk.ErrNoNode {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			return data, nil
+		}
+		select {
+		case <-ev:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// item claimed by consumer, should be acked after processed
+type QueueItem struct {
+	Name    string
+	Data    []byte
+	q       *Queue
+	version int32 // item node version bumped by claim
+}
+
+// claim the first unclaimed item, block until one is available or ctx done
+func (q *Queue) Claim(ctx context.Context) (*QueueItem, error) {
+	for {
+		children, ev, err := q.watchChildren(q.dir)
+		if err != nil {
+			return nil, err
+		}
+		claims, claimEv, err := q.watchChildren(q.claimDir)
+		if err != nil {
+			return nil, err
+		}
+		claimed := make(map[string]bool, len(claims))
+		for _, c := range claims {
+			claimed[c] = true
+		}
+		for _, name := range sortQueueItems(children) {
+			if claimed[name] {
+				continue
+			}
+			item, err := q.claim(name)
+			if err != nil {
+				return nil, err
+			}
+			if item != nil {
+				return item, nil
+			}
+		}
+		select {
+		case <-ev:
+		case <-claimEv:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// return nil item if claimed by others or acked
+func (q *Queue) claim(name string) (*QueueItem, error) {
+	claim := JoinPath(q.claimDir, name)
+	_, err := q.cli.CreateWithData(claim, nil, ModeEphemeral)
+	if err == zk.ErrNodeExists {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	npath := JoinPath(q.dir, name)
+	data, version, err := q.cli.GetData(npath)
+	if err == nil {
+		version, err = q.cli.CompareAndSet(npath, data, version)
+	}
+	if err != nil {
+		q.cli.DeleteNode(claim)
+		// acked by others, or claimed by others after claim node of ours is gone
+		if err == zk.ErrNoNode || err == ErrVersionConflict {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &QueueItem{Name: name, Data: data, q: q, version: version}, nil
+}
+
+// claim is lost if claim node or item node has gone, or item is claimed by others
+func claimLost(err error) error {
+	if txnErr, ok := err.(*TxnError); ok && (txnErr.Err == zk.ErrNoNode || txnErr.Err == ErrVersionConflict) {
+		return ErrClaimLost
+	}
+	return err
+}
+
+// remove item and its claim atomically, return ErrClaimLost if claim is not owned any more
+func (item *QueueItem) Ack() error {
+	_, err := item.q.cli.Txn().
+		Delete(JoinPath(item.q.claimDir, item.Name), anyVersion).
+		Delete(JoinPath(item.q.dir, item.Name), item.version).
+		Commit()
+	return claimLost(err)
+}
+
+// give up claim, item becomes visible to other consumers
+func (item *QueueItem) Release() error {
+	_, err := item.q.cli.Txn().
+		Check(JoinPath(item.q.dir, item.Name), item.version).
+		Delete(JoinPath(item.q.claimDir, item.Name), anyVersion).
+		Commit()
+	return claimLost(err)
+}
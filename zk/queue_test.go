@@ -0,0 +1,163 @@
+package zk
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSortQueueItems(t *testing.T) {
+	children := []string{"item-001-0000000003", "item-000-0000000004", "claim",
+		"item-000-0000000002", "item-1-0000000005", "item-001-0000000001"}
+	expect := []string{"item-000-0000000002", "item-000-0000000004",
+		"item-001-0000000001", "item-001-0000000003", "item-1-0000000005"}
+	if names := sortQueueItems(children); !reflect.DeepEqual(names, expect) {
+		t.Log(names)
+		t.Fail()
+	}
+}
+
+func TestQueueTake(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	dir := "/test/queue"
+	defer cli.DeleteNode(dir)
+	q := NewQueue(cli, dir)
+	if _, err := q.PutPriority(nil, MaxPriority+1); err != ErrInvalidPriority {
+		t.Log(err)
+		t.Fail()
+	}
+	for _, d := range []string{"a", "b"} {
+		if _, err := q.Put([]byte(d)); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+	}
+	q.PutPriority([]byte("c"), MaxPriority)
+	q.PutPriority([]byte("urgent"), 0)
+	if n, err := q.Len(); err != nil || n != 4 {
+		t.Log(n, err)
+		t.Fail()
+	}
+	for _, expect := range []string{"a", "b", "urgent", "c"} {
+		data, err := q.Take(context.Background())
+		if err != nil || string(data) != expect {
+			t.Log(string(data), err)
+			t.Fail()
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if _, err := q.Take(ctx); err != context.DeadlineExceeded {
+		t.Log(err)
+		t.Fail()
+	}
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		q.Put([]byte("late"))
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	if data, err := q.Take(ctx); err != nil || string(data) != "late" {
+		t.Log(string(data), err)
+		t.Fail()
+	}
+}
+
+func TestQueueClaim(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	dir := "/test/claimqueue"
+	defer cli.DeleteNode(dir)
+	defer cli.DeleteNode(dir + "/" + queueClaimDir)
+	q := NewQueue(cli, dir)
+	q.Put([]byte("a"))
+	q.Put([]byte("b"))
+
+	crashed := NewZKClient(testServers, time.Second*5, nil)
+	item, err := NewQueue(crashed, dir).Claim(context.Background())
+	if err != nil || string(item.Data) != "a" {
+		t.Log(item, err)
+		t.FailNow()
+	}
+	item, err = q.Claim(context.Background())
+	if err != nil || string(item.Data) != "b" {
+		t.Log(item, err)
+		t.FailNow()
+	}
+	if err := item.Ack(); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+	if err := item.Ack(); err != ErrClaimLost {
+		t.Log(err)
+		t.Fail()
+	}
+
+	// claim of closed session disappears and item is visible again
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		crashed.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	item, err = q.Claim(ctx)
+	if err != nil || string(item.Data) != "a" {
+		t.Log(item, err)
+		t.FailNow()
+	}
+	if err := item.Release(); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+	if data, err := q.Take(ctx); err != nil || string(data) != "a" {
+		t.Log(string(data), err)
+		t.Fail()
+	}
+}
+
+func TestQueueStaleClaim(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	dir := "/test/stalequeue"
+	defer cli.DeleteNode(dir)
+	defer cli.DeleteNode(dir + "/" + queueClaimDir)
+	q := NewQueue(cli, dir)
+	q.Put([]byte("a"))
+
+	stale, err := q.Claim(context.Background())
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	// claim node of stale consumer is gone, such as session expired, then item is claimed again
+	cli.DeleteNode(dir + "/" + queueClaimDir + "/" + stale.Name)
+	owner, err := q.Claim(context.Background())
+	if err != nil || owner.Name != stale.Name {
+		t.Log(owner, err)
+		t.FailNow()
+	}
+	if err := stale.Ack(); err != ErrClaimLost {
+		t.Log(err)
+		t.Fail()
+	}
+	if err := stale.Release(); err != ErrClaimLost {
+		t.Log(err)
+		t.Fail()
+	}
+	ok, err := cli.NodeExist(dir + "/" + queueClaimDir + "/" + owner.Name)
+	if err != nil || !ok {
+		t.Log("claim of owner removed", err)
+		t.FailNow()
+	}
+	if err := owner.Ack(); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+	if n, err := q.Len(); err != nil || n != 0 {
+		t.Log(n, err)
+		t.Fail()
+	}
+}
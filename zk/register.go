@@ -6,41 +6,75 @@ import (
 	"time"
 
 	log "github.com/alecthomas/log4go"
+
+	"github.com/samuel/go-zookeeper/zk"
 )
 
 var (
 	errRegisterClosed = errors.New("register closed")
+	ErrNotRegistered  = errors.New("address not registered")
 )
 
+type registerOp int
+
+const (
+	opRegister registerOp = iota
+	opDeregister
+	opUpdateMeta
+)
+
+type registerReq struct {
+	op   registerOp
+	addr string
+	data []byte
+}
+
 // register service address to zookeeper path
 type ZKRegister struct {
 	closed       bool
 	cli          *ZKClient
 	servicePath  string
-	serviceAddrs []string
+	serviceAddrs map[string][]byte // addr -> node data
 	mutex        *sync.Mutex
-	addrChan     chan string
+	reqChan      chan registerReq
 	errChan      chan error
 }
 
 func NewZKRegister(servers []string, sessionTimeout time.Duration,
 	servicePath string) *ZKRegister {
+	return newZKRegister(NewZKClient(servers, sessionTimeout, nil), servicePath)
+}
+
+// register with auth credentials, service nodes are created with opt.ACL
+func NewZKRegisterWithACL(servers []string, sessionTimeout time.Duration,
+	servicePath string, opt ACLOption) (*ZKRegister, error) {
+	cli := NewZKClient(servers, sessionTimeout, nil)
+	// client not connected, auth is only saved
+	if err := cli.SetACLOption(opt); err != nil {
+		return nil, err
+	}
+	return newZKRegister(cli, servicePath), nil
+}
+
+func newZKRegister(cli *ZKClient, servicePath string) *ZKRegister {
 	r := &ZKRegister{
-		cli:          NewZKClient(servers, sessionTimeout, nil),
+		cli:          cli,
 		servicePath:  servicePath,
-		serviceAddrs: make([]string, 0),
+		serviceAddrs: make(map[string][]byte),
 		mutex:        &sync.Mutex{},
-		addrChan:     make(chan string),
+		reqChan:      make(chan registerReq),
 		errChan:      make(chan error),
 	}
 	onZKSessionFunc := func(zkCli *ZKClient) {
 		r.mutex.Lock()
-		addrs := make([]string, len(r.serviceAddrs))
-		copy(addrs, r.serviceAddrs)
-		r.mutex.Unlock()
-		for _, addr := range addrs {
-			npath := r.servicePath + "/" + addr
-			err := zkCli.CreateEphemeralNode(npath)
+		defer r.mutex.Unlock()
+		for addr, data := range r.serviceAddrs {
+			npath := JoinPath(r.servicePath, addr)
+			_, err := zkCli.CreateWithData(npath, data, ModeEphemeral)
+			if err == zk.ErrNodeExists {
+				// keep meta updated during disconnection
+				err = zkCli.SetData(npath, data)
+			}
 			if err != nil {
 				log.Error("create node:%s failed on session build, error:%v", npath, err)
 			}
@@ -48,33 +82,89 @@ func NewZKRegister(servers []string, sessionTimeout time.Duration,
 	}
 	r.cli.SetFuncOnSessionBuild(onZKSessionFunc)
 	go func() {
-		for addr := range r.addrChan {
-			npath := r.servicePath + "/" + addr
-			err := r.cli.CreateEphemeralNode(npath)
-			r.errChan <- err
+		for req := range r.reqChan {
+			r.errChan <- r.do(req)
 		}
 	}()
 	return r
 }
 
-func (r *ZKRegister) Register(addr string) error {
+// apply request to zk node and registered address, lock is held to prevent
+// session build func from recreating node at the same time
+func (r *ZKRegister) do(req registerReq) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	npath := JoinPath(r.servicePath, req.addr)
+	switch req.op {
+	case opRegister:
+		_, err := r.cli.CreateWithData(npath, req.data, ModeEphemeral)
+		if err != nil {
+			return err
+		}
+		r.serviceAddrs[req.addr] = req.data
+	case opDeregister:
+		if _, ok := r.serviceAddrs[req.addr]; !ok {
+			return ErrNotRegistered
+		}
+		err := r.cli.DeleteNode(npath)
+		if err != nil && err != zk.ErrNoNode {
+			return err
+		}
+		delete(r.serviceAddrs, req.addr)
+	case opUpdateMeta:
+		if _, ok := r.serviceAddrs[req.addr]; !ok {
+			return ErrNotRegistered
+		}
+		err := r.cli.SetData(npath, req.data)
+		if err == zk.ErrNoNode {
+			// node gone with expired session, not created yet
+			_, err = r.cli.CreateWithData(npath, req.data, ModeEphemeral)
+		}
+		if err != nil {
+			return err
+		}
+		r.serviceAddrs[req.addr] = req.data
+	}
+	return nil
+}
+
+func (r *ZKRegister) request(req registerReq) error {
 	if r.closed {
 		return errRegisterClosed
 	}
-	r.addrChan <- addr
-	err := <-r.errChan
+	r.reqChan <- req
+	return <-r.errChan
+}
+
+func (r *ZKRegister) Register(addr string) error {
+	return r.request(registerReq{op: opRegister, addr: addr, data: []byte("")})
+}
+
+// register address with meta stored as node data
+func (r *ZKRegister) RegisterWithMeta(addr string, meta *ServiceMeta) error {
+	data, err := meta.Marshal()
 	if err != nil {
 		return err
 	}
-	r.mutex.Lock()
-	r.serviceAddrs = append(r.serviceAddrs, addr)
-	r.mutex.Unlock()
-	return nil
+	return r.request(registerReq{op: opRegister, addr: addr, data: data})
+}
+
+// remove address node, it would not be created again on session build
+func (r *ZKRegister) Deregister(addr string) error {
+	return r.request(registerReq{op: opDeregister, addr: addr})
+}
+
+func (r *ZKRegister) UpdateMeta(addr string, meta *ServiceMeta) error {
+	data, err := meta.Marshal()
+	if err != nil {
+		return err
+	}
+	return r.request(registerReq{op: opUpdateMeta, addr: addr, data: data})
 }
 
 func (r *ZKRegister) Close() {
 	r.closed = true
-	close(r.addrChan)
+	close(r.reqChan)
 	close(r.errChan)
 	r.cli.Close()
 }
@@ -24,4 +24,90 @@ func TestRegister(t *testing.T) {
 		t.FailNow()
 	}
 	t.Log(nodes)
-}
\ No newline at end of file
+}
+func TestRegisterWithMeta(t *testing.T) {
+	spath := "/test/meta"
+	r := NewZKRegister(testServers, time.Second*5, spath)
+	defer r.Close()
+	addr := "127.0.0.1:8080"
+	err := r.RegisterWithMeta(addr, &ServiceMeta{Weight: 10, Zone: "z1"})
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	err = r.UpdateMeta(addr, &ServiceMeta{Weight: 20, Zone: "z1", Tags: []string{"canary"}})
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	data, _, err := r.cli.GetData(spath + "/" + addr)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	meta, err := ParseServiceMeta(data)
+	if err != nil || meta.Weight != 20 || len(meta.Tags) != 1 {
+		t.Log(string(data), err)
+		t.FailNow()
+	}
+
+	if r.UpdateMeta("127.0.0.1:8081", meta) != ErrNotRegistered {
+		t.Fail()
+	}
+	err = r.Deregister(addr)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	ok, err := r.cli.NodeExist(spath + "/" + addr)
+	if err != nil || ok {
+		t.Fail()
+	}
+}
+
+func TestRegisterSessionExpire(t *testing.T) {
+	if testSrv == nil {
+		t.Skip("session expiry needs in-memory server")
+	}
+	spath := "/test/expire"
+	r := NewZKRegister(testServers, time.Second*5, spath)
+	defer r.Close()
+	addr := "127.0.0.1:8080"
+	if err := r.Register(addr); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	states := r.cli.WatchSessionState()
+	defer states.Close()
+
+	testSrv.ExpireAllSessions()
+	for ev := range states.States {
+		if ev.State == StateHasSession {
+			break
+		}
+	}
+	// node is created again by session build func
+	time.Sleep(time.Millisecond * 100)
+	ok, err := r.cli.NodeExist(spath + "/" + addr)
+	if err != nil || !ok {
+		t.Log(err)
+		t.Fail()
+	}
+}
+
+func TestParseServiceMeta(t *testing.T) {
+	meta, err := ParseServiceMeta(nil)
+	if err != nil || meta.Weight != 0 {
+		t.FailNow()
+	}
+	data, _ := (&ServiceMeta{Weight: 5, HealthPort: 8081}).Marshal()
+	meta, err = ParseServiceMeta(data)
+	if err != nil || meta.Weight != 5 || meta.HealthPort != 8081 {
+		t.Log(string(data), err)
+		t.FailNow()
+	}
+	_, err = ParseServiceMeta([]byte("{"))
+	if err == nil {
+		t.Fail()
+	}
+}
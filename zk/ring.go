@@ -0,0 +1,146 @@
+package zk
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// ketama consistent hash ring over valid service cli.
+// every address is placed on ring by virtual nodes, key is mapped to the first
+// virtual node clockwise, so that address change only remaps keys of that address.
+
+const (
+	DefaultVirtualNodes = 160
+	// weight is clamped so that misconfigured weight does not build huge ring
+	maxRingWeight = 100
+)
+
+type ConsistentHashOption struct {
+	VirtualNodes int                 // virtual nodes of each address, default DefaultVirtualNodes
+	UseWeight    bool                // multiply virtual nodes by meta weight, which is at most 100
+	HashFn       func([]byte) uint32 // key hash func, default fnv-1a
+}
+
+type ringPoint struct {
+	hash uint32
+	idx  int // index of servCli
+}
+
+type hashRing struct {
+	gen     uint64
+	servCli []monitorServCli
+	points  []ringPoint
+}
+
+type ConsistentHashGetter struct {
+	zkm  *ZKMonitor
+	opt  ConsistentHashOption
+	lock *sync.Mutex
+	ring atomic.Value // store *hashRing
+}
+
+func (zkm *ZKMonitor) ConsistentHashGetter(opt ConsistentHashOption) *ConsistentHashGetter {
+	if opt.VirtualNodes <= 0 {
+		opt.VirtualNodes = DefaultVirtualNodes
+	}
+	if opt.HashFn == nil {
+		opt.HashFn = func(data []byte) uint32 {
+			h := fnv.New32a()
+			h.Write(data)
+			return h.Sum32()
+		}
+	}
+	g := &ConsistentHashGetter{
+		zkm:  zkm,
+		opt:  opt,
+		lock: &sync.Mutex{},
+	}
+	g.ring.Store(&hashRing{})
+	return g
+}
+
+// each md5 digest of "addr-i" gives 4 ring points
+func ketamaPoints(addr string, n int) []uint32 {
+	points := make([]uint32, 0, n)
+	for i := 0; len(points) < n; i++ {
+		digest := md5.Sum([]byte(addr + "-" + strconv.Itoa(i)))
+		for j := 0; j < 4 && len(points) < n; j++ {
+			points = append(points, binary.LittleEndian.Uint32(digest[j*4:]))
+		}
+	}
+	return points
+}
+
+func (g *ConsistentHashGetter) buildRing(gen uint64, servCli []monitorServCli) *hashRing {
+	ring := &hashRing{
+		gen:     gen,
+		servCli: servCli,
+	}
+	for i := range servCli {
+		n := g.opt.VirtualNodes
+		if g.opt.UseWeight {
+			w := servCli[i].weight()
+			if w > maxRingWeight {
+				w = maxRingWeight
+			}
+			n *= w
+		}
+		for _, p := range ketamaPoints(servCli[i].addr, n) {
+			ring.points = append(ring.points, ringPoint{hash: p, idx: i})
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool {
+		return ring.points[i].hash < ring.points[j].hash
+	})
+	return ring
+}
+
+// rebuild ring if service cli in use changed
+func (g *ConsistentHashGetter) currentRing() *hashRing {
+	gen := atomic.LoadUint64(&g.zkm.inUseGen)
+	ring := g.ring.Load().(*hashRing)
+	if ring.gen == gen && ring.servCli != nil {
+		return ring
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	ring = g.ring.Load().(*hashRing)
+	if ring.gen == gen && ring.servCli != nil {
+		return ring
+	}
+	ring = g.buildRing(gen, g.zkm.validServClients())
+	g.ring.Store(ring)
+	return ring
+}
+
+// find first valid cli clockwise from key hash
+func (g *ConsistentHashGetter) GetConn(key []byte) ServiceConn {
+	conn, _ := g.GetConnAddr(key)
+	return conn
+}
+
+// same as GetConn, also return address of conn for ZKMonitor.ReportFailure and ReportSuccess
+func (g *ConsistentHashGetter) GetConnAddr(key []byte) (ServiceConn, string) {
+	ring := g.currentRing()
+	sz := len(ring.points)
+	if sz == 0 {
+		return nil, ""
+	}
+	h := g.opt.HashFn(key)
+	start := sort.Search(sz, func(i int) bool {
+		return ring.points[i].hash >= h
+	})
+	for k := 0; k < sz; k++ {
+		idx := ring.points[(start+k)%sz].idx
+		if ring.servCli[idx].isValid() {
+			return ring.servCli[idx].allocConnAddr()
+		}
+	}
+	return nil, ""
+}
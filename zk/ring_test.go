@@ -0,0 +1,98 @@
+package zk
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestKetamaPoints(t *testing.T) {
+	points := ketamaPoints("127.0.0.1:6379", 10)
+	if len(points) != 10 {
+		t.FailNow()
+	}
+	again := ketamaPoints("127.0.0.1:6379", 10)
+	for i := range points {
+		if points[i] != again[i] {
+			t.FailNow()
+		}
+	}
+}
+
+func TestConsistentHashGet(t *testing.T) {
+	zkm := newTestMonitor(&testAddrService{})
+	g := zkm.ConsistentHashGetter(ConsistentHashOption{})
+	if g.GetConn([]byte("key")) != nil {
+		t.FailNow()
+	}
+
+	addrs := make([]string, 0)
+	for i := 0; i < 10; i++ {
+		addrs = append(addrs, fmt.Sprintf("10.0.0.%d:6379", i))
+	}
+	zkm.onAddrChange(addrs)
+
+	keys := 10000
+	mapping := make(map[string]string, keys)
+	cnt := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key%d", i)
+		addr := g.GetConn([]byte(key)).(string)
+		mapping[key] = addr
+		cnt[addr]++
+	}
+	for _, addr := range addrs {
+		if cnt[addr] < keys/len(addrs)/2 {
+			t.Log(cnt)
+			t.FailNow()
+		}
+	}
+
+	// remove one address, only its keys should be remapped
+	removed := addrs[3]
+	zkm.onAddrChange(append(addrs[:3:3], addrs[4:]...))
+	moved := 0
+	for key, addr := range mapping {
+		now := g.GetConn([]byte(key)).(string)
+		if now == removed {
+			t.FailNow()
+		}
+		if now != addr {
+			moved++
+			if addr != removed {
+				t.Log(key, addr, now)
+				t.FailNow()
+			}
+		}
+	}
+	if moved != cnt[removed] {
+		t.Log(moved, cnt[removed])
+		t.Fail()
+	}
+}
+
+func TestConsistentHashWeight(t *testing.T) {
+	zkm := newTestMonitor(&testAddrService{})
+	g := zkm.ConsistentHashGetter(ConsistentHashOption{VirtualNodes: 100, UseWeight: true})
+	zkm.onServiceChange([]ServiceAddr{
+		{"a", &ServiceMeta{Weight: 3}},
+		{"b", nil},
+	})
+	cnt := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		cnt[g.GetConn([]byte(fmt.Sprintf("key%d", i))).(string)]++
+	}
+	if cnt["a"] < cnt["b"]*2 {
+		t.Log(cnt)
+		t.Fail()
+	}
+
+	// weight is clamped
+	zkm.onServiceChange([]ServiceAddr{
+		{"a", &ServiceMeta{Weight: 10000}},
+		{"b", nil},
+	})
+	if n := len(g.currentRing().points); n != 100*(maxRingWeight+1) {
+		t.Log(n)
+		t.Fail()
+	}
+}
@@ -1,5 +1,7 @@
 package zk
 
+import "encoding/json"
+
 // service connection interface
 type ServiceConn interface{}
 
@@ -16,4 +18,47 @@ type ServiceCli interface {
 // when got new address event call InitCli
 type Service interface {
 	InitCli(addr string, arg interface{}) ServiceCli
-}
\ No newline at end of file
+}
+
+// optional interface of Service, called instead of InitCli if implemented
+// meta is nil if address monitor does not support meta or node data is not valid meta
+type MetaService interface {
+	InitCliWithMeta(addr string, meta *ServiceMeta, arg interface{}) ServiceCli
+}
+
+// optional interface of ServiceCli, called when meta changed but address still exist
+type MetaServiceCli interface {
+	OnMetaUpdate(meta *ServiceMeta)
+}
+
+// service address with meta parsed from node data
+type ServiceAddr struct {
+	Addr string
+	Meta *ServiceMeta
+}
+
+// service instance metadata, stored in register node as json
+type ServiceMeta struct {
+	Weight     int      `json:"weight,omitempty" yaml:"weight,omitempty"`
+	Zone       string   `json:"zone,omitempty" yaml:"zone,omitempty"`
+	Version    string   `json:"version,omitempty" yaml:"version,omitempty"`
+	Tags       []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	HealthPort int      `json:"healthPort,omitempty" yaml:"healthPort,omitempty"`
+}
+
+func (m *ServiceMeta) Marshal() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// empty data is parsed as zero ServiceMeta, for node registered without meta
+func ParseServiceMeta(data []byte) (*ServiceMeta, error) {
+	meta := &ServiceMeta{}
+	if len(data) == 0 {
+		return meta, nil
+	}
+	err := json.Unmarshal(data, meta)
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
@@ -0,0 +1,120 @@
+package zk
+
+import (
+	"sync/atomic"
+
+	log "github.com/alecthomas/log4go"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// session state of ZKClient connection
+type SessionState int32
+
+const (
+	StateUnknown SessionState = iota
+	StateConnecting
+	StateConnected
+	StateHasSession
+	StateDisconnected
+	StateExpired
+	StateAuthFailed
+)
+
+const stateWatcherBufferSize = 16
+
+var sessionStateNames = map[SessionState]string{
+	StateUnknown:      "unknown",
+	StateConnecting:   "connecting",
+	StateConnected:    "connected",
+	StateHasSession:   "has-session",
+	StateDisconnected: "disconnected",
+	StateExpired:      "expired",
+	StateAuthFailed:   "auth-failed",
+}
+
+func (s SessionState) String() string {
+	if name, ok := sessionStateNames[s]; ok {
+		return name
+	}
+	return sessionStateNames[StateUnknown]
+}
+
+// convert go-zookeeper state, return false if the state is not reported to watchers
+func toSessionState(s zk.State) (SessionState, bool) {
+	switch s {
+	case zk.StateConnecting:
+		return StateConnecting, true
+	case zk.StateConnected:
+		return StateConnected, true
+	case zk.StateHasSession:
+		return StateHasSession, true
+	case zk.StateDisconnected:
+		return StateDisconnected, true
+	case zk.StateExpired:
+		return StateExpired, true
+	case zk.StateAuthFailed:
+		return StateAuthFailed, true
+	}
+	return StateUnknown, false
+}
+
+type SessionEvent struct {
+	State  SessionState
+	Server string
+}
+
+// receive session state change of ZKClient
+// if reader is too slow, event will be dropped when buffer is full
+type StateWatcher struct {
+	closed bool
+	cli    *ZKClient
+	States chan SessionEvent
+}
+
+func (w *StateWatcher) Close() {
+	w.cli.stateLock.Lock()
+	defer w.cli.stateLock.Unlock()
+	if w.closed {
+		return
+	}
+	w.closed = true
+	delete(w.cli.stateWatchers, w)
+	close(w.States)
+}
+
+// watch session state, call StateWatcher.Close after use
+func (cli *ZKClient) WatchSessionState() *StateWatcher {
+	w := &StateWatcher{
+		cli:    cli,
+		States: make(chan SessionEvent, stateWatcherBufferSize),
+	}
+	cli.stateLock.Lock()
+	cli.stateWatchers[w] = struct{}{}
+	cli.stateLock.Unlock()
+	return w
+}
+
+// current session state
+func (cli *ZKClient) State() SessionState {
+	return SessionState(atomic.LoadInt32(&cli.state))
+}
+
+func (cli *ZKClient) onSessionEvent(e zk.Event) {
+	s, ok := toSessionState(e.State)
+	if !ok {
+		return
+	}
+	atomic.StoreInt32(&cli.state, int32(s))
+
+	ev := SessionEvent{State: s, Server: e.Server}
+	cli.stateLock.RLock()
+	defer cli.stateLock.RUnlock()
+	for w := range cli.stateWatchers {
+		select {
+		case w.States <- ev:
+		default:
+			log.Error("state watcher buffer full, drop event:%s", s)
+		}
+	}
+}
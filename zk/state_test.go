@@ -0,0 +1,57 @@
+package zk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestWatchSessionState(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	w := cli.WatchSessionState()
+
+	events := []zk.State{zk.StateConnecting, zk.StateConnected, zk.StateHasSession,
+		zk.StateSaslAuthenticated, zk.StateDisconnected, zk.StateExpired}
+	expect := []SessionState{StateConnecting, StateConnected, StateHasSession,
+		StateDisconnected, StateExpired}
+	for _, s := range events {
+		cli.onSessionEvent(zk.Event{Type: zk.EventSession, State: s, Server: "127.0.0.1:2181"})
+	}
+	for _, s := range expect {
+		ev := <-w.States
+		if ev.State != s || ev.Server != "127.0.0.1:2181" {
+			t.Log(ev)
+			t.FailNow()
+		}
+	}
+	if cli.State() != StateExpired {
+		t.Log(cli.State())
+		t.Fail()
+	}
+
+	w.Close()
+	w.Close()
+	if _, ok := <-w.States; ok {
+		t.Fail()
+	}
+	// no panic after watcher closed
+	cli.onSessionEvent(zk.Event{Type: zk.EventSession, State: zk.StateHasSession})
+	if cli.State() != StateHasSession {
+		t.Fail()
+	}
+}
+
+func TestStateWatcherDropOnFull(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	w := cli.WatchSessionState()
+	defer w.Close()
+
+	for i := 0; i < stateWatcherBufferSize*2; i++ {
+		cli.onSessionEvent(zk.Event{Type: zk.EventSession, State: zk.StateConnecting})
+	}
+	if len(w.States) != stateWatcherBufferSize {
+		t.Log(len(w.States))
+		t.Fail()
+	}
+}
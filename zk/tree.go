@@ -0,0 +1,375 @@
+package zk
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/alecthomas/log4go"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+type TreeEventType int
+
+const (
+	TreeNodeAdded TreeEventType = iota
+	TreeNodeUpdated
+	TreeNodeRemoved
+	TreeInitialized // first sync of whole tree finished
+)
+
+var treeEventNames = map[TreeEventType]string{
+	TreeNodeAdded:   "added",
+	TreeNodeUpdated: "updated",
+	TreeNodeRemoved: "removed",
+	TreeInitialized: "initialized",
+}
+
+func (t TreeEventType) String() string {
+	return treeEventNames[t]
+}
+
+// Data is the latest node data, or the last cached data on remove
+type TreeEvent struct {
+	Type TreeEventType
+	Path string
+	Data []byte
+}
+
+type treeNode struct {
+	data         []byte
+	version      int32
+	children     map[string]struct{}
+	dataWatched  bool
+	childWatched bool
+}
+
+type watchKind int
+
+const (
+	watchData watchKind = iota
+	watchChildren
+	watchExist
+)
+
+const treeEventBufferSize = 256
+
+type treeWatch struct {
+	path string
+	kind watchKind
+	ev   zk.Event
+}
+
+// mirror the whole subtree under root path in memory, include node data and children.
+// every node is watched by GetW and ChildrenW, cache is re-synced after session rebuilt
+// so that watches lost with expired session would be placed again.
+// Events should be drained by caller, events are dropped and counted by Dropped once its buffer
+// is full, so that slow consumer does not stop cache from updating. query the cache after drop.
+type TreeCache struct {
+	dropped     int64 // atomic
+	closed      bool
+	cli         *ZKClient
+	root        string
+	lock        *sync.RWMutex
+	nodes       map[string]*treeNode
+	rootWatched bool
+	watchCh     chan treeWatch
+	stop        chan struct{}
+	exited      chan struct{}
+	Events      chan TreeEvent
+}
+
+// create tree cache and start to sync root path
+func NewTreeCache(cli *ZKClient, root string) *TreeCache {
+	root = JoinPath(root)
+	c := &TreeCache{
+		cli:     cli,
+		root:    root,
+		lock:    &sync.RWMutex{},
+		nodes:   make(map[string]*treeNode),
+		watchCh: make(chan treeWatch),
+		stop:    make(chan struct{}),
+		exited:  make(chan struct{}),
+		Events:  make(chan TreeEvent, treeEventBufferSize),
+	}
+	sw := cli.WatchSessionState()
+	sched := make(chan struct{})
+	go func() {
+		close(sched)
+		defer close(c.exited)
+		defer sw.Close()
+
+		c.sync(c.root)
+		c.emit(TreeEvent{Type: TreeInitialized, Path: c.root})
+		for {
+			select {
+			case w := <-c.watchCh:
+				c.onWatch(w)
+			case e := <-sw.States:
+				if e.State == StateHasSession {
+					log.Debug("tree cache:%s resync on session build", c.root)
+					c.sync(c.root)
+				}
+			case <-c.stop:
+				log.Info("stop tree cache:%s", c.root)
+				return
+			}
+		}
+	}()
+	<-sched
+	return c
+}
+
+func (c *TreeCache) Close() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.stop)
+	<-c.exited
+	close(c.Events)
+}
+
+// return cached node data, data should not be modified
+func (c *TreeCache) Get(npath string) ([]byte, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	node, ok := c.nodes[npath]
+	if !ok {
+		return nil, false
+	}
+	return node.data, true
+}
+
+// return cached children names in order
+func (c *TreeCache) Children(npath string) ([]string, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	node, ok := c.nodes[npath]
+	if !ok {
+		return nil, false
+	}
+	children := make([]string, 0, len(node.children))
+	for child := range node.children {
+		children = append(children, child)
+	}
+	sort.Strings(children)
+	return children, true
+}
+
+// copy of all cached node data, keyed by node path
+func (c *TreeCache) Snapshot() map[string][]byte {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	snapshot := make(map[string][]byte, len(c.nodes))
+	for npath, node := range c.nodes {
+		snapshot[npath] = node.data
+	}
+	return snapshot
+}
+
+// never block cache loop
+func (c *TreeCache) emit(e TreeEvent) {
+	select {
+	case c.Events <- e:
+	default:
+		atomic.AddInt64(&c.dropped, 1)
+		log.Error("tree cache:%s events buffer full, drop event:%s %s", c.root, e.Type, e.Path)
+	}
+}
+
+// number of events dropped because Events buffer was full
+func (c *TreeCache) Dropped() int64 {
+	return atomic.LoadInt64(&c.dropped)
+}
+
+// forward one-shot watch event to cache loop
+func (c *TreeCache) forward(npath string, kind watchKind, ch <-chan zk.Event) {
+	go func() {
+		select {
+		case e := <-ch:
+			select {
+			case c.watchCh <- treeWatch{path: npath, kind: kind, ev: e}:
+			case <-c.stop:
+			}
+		case <-c.stop:
+		}
+	}()
+}
+
+func (c *TreeCache) onWatch(w treeWatch) {
+	log.Debug("tree cache recv path:%s event:%s", w.path, w.ev)
+	c.lock.Lock()
+	switch w.kind {
+	case watchExist:
+		c.rootWatched = false
+	case watchData:
+		if node, ok := c.nodes[w.path]; ok {
+			node.dataWatched = false
+		}
+	case watchChildren:
+		if node, ok := c.nodes[w.path]; ok {
+			node.childWatched = false
+		}
+	}
+	c.lock.Unlock()
+	c.sync(w.path)
+}
+
+// load node and its subtree, place the watches which are not present
+func (c *TreeCache) sync(npath string) {
+	c.lock.RLock()
+	node := c.nodes[npath]
+	dataWatched := node != nil && node.dataWatched
+	childWatched := node != nil && node.childWatched
+	c.lock.RUnlock()
+
+	if !dataWatched {
+		data, stat, ev, err := c.cli.getW(npath)
+		if err == zk.ErrNoNode {
+			c.removeSubtree(npath)
+			if npath == c.root {
+				c.watchRootExist()
+			}
+			return
+		}
+		if err != nil {
+			log.Error("sync tree path:%s data error:%v", npath, err)
+			return
+		}
+		c.forward(npath, watchData, ev)
+		c.setData(npath, data, stat.Version)
+	}
+	if !childWatched {
+		children, ev, err := c.cli.childrenW(npath)
+		if err == zk.ErrNoNode {
+			c.removeSubtree(npath)
+			return
+		}
+		if err != nil {
+			log.Error("sync tree path:%s children error:%v", npath, err)
+			return
+		}
+		c.forward(npath, watchChildren, ev)
+		c.setChildren(npath, children)
+	}
+
+	c.lock.RLock()
+	node = c.nodes[npath]
+	children := make([]string, 0)
+	if node != nil {
+		for child := range node.children {
+			children = append(children, child)
+		}
+	}
+	c.lock.RUnlock()
+	for _, child := range children {
+		c.sync(JoinPath(npath, child))
+	}
+}
+
+func (c *TreeCache) watchRootExist() {
+	c.lock.Lock()
+	watched := c.rootWatched
+	c.rootWatched = true
+	c.lock.Unlock()
+	if watched {
+		return
+	}
+	exist, ev, err := c.cli.existsW(c.root)
+	if err != nil {
+		c.lock.Lock()
+		c.rootWatched = false
+		c.lock.Unlock()
+		log.Error("watch tree root:%s error:%v", c.root, err)
+		return
+	}
+	c.forward(c.root, watchExist, ev)
+	if exist {
+		c.sync(c.root)
+	}
+}
+
+func (c *TreeCache) setData(npath string, data []byte, version int32) {
+	c.lock.Lock()
+	node, ok := c.nodes[npath]
+	if !ok {
+		node = &treeNode{
+			data:     data,
+			version:  version,
+			children: make(map[string]struct{}),
+		}
+		c.nodes[npath] = node
+	}
+	node.dataWatched = true
+	updated := ok && node.version != version
+	if updated {
+		node.data = data
+		node.version = version
+	}
+	c.lock.Unlock()
+
+	if !ok {
+		c.emit(TreeEvent{Type: TreeNodeAdded, Path: npath, Data: data})
+	} else if updated {
+		c.emit(TreeEvent{Type: TreeNodeUpdated, Path: npath, Data: data})
+	}
+}
+
+// replace children of node, subtree of vanished children would be removed
+func (c *TreeCache) setChildren(npath string, children []string) {
+	c.lock.Lock()
+	node, ok := c.nodes[npath]
+	if !ok {
+		c.lock.Unlock()
+		return
+	}
+	node.childWatched = true
+	current := make(map[string]struct{}, len(children))
+	for _, child := range children {
+		current[child] = struct{}{}
+	}
+	var removed []string
+	for child := range node.children {
+		if _, ok := current[child]; !ok {
+			removed = append(removed, child)
+		}
+	}
+	node.children = current
+	c.lock.Unlock()
+
+	for _, child := range removed {
+		c.removeSubtree(JoinPath(npath, child))
+	}
+}
+
+// remove node and all its descendants, deepest node first
+func (c *TreeCache) removeSubtree(npath string) {
+	prefix := strings.TrimSuffix(npath, pathSeparator) + pathSeparator
+	c.lock.Lock()
+	removed := make([]string, 0)
+	for p := range c.nodes {
+		if p == npath || strings.HasPrefix(p, prefix) {
+			removed = append(removed, p)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool {
+		return removed[i] > removed[j]
+	})
+	events := make([]TreeEvent, 0, len(removed))
+	for _, p := range removed {
+		events = append(events, TreeEvent{Type: TreeNodeRemoved, Path: p, Data: c.nodes[p].data})
+		delete(c.nodes, p)
+	}
+	parent, name := SplitPath(npath)
+	if node, ok := c.nodes[parent]; ok && npath != c.root {
+		delete(node.children, name)
+	}
+	c.lock.Unlock()
+
+	for _, e := range events {
+		c.emit(e)
+	}
+}
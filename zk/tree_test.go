@@ -0,0 +1,200 @@
+package zk
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestTreeCache(root string) *TreeCache {
+	return &TreeCache{
+		root:   root,
+		lock:   &sync.RWMutex{},
+		nodes:  make(map[string]*treeNode),
+		stop:   make(chan struct{}),
+		Events: make(chan TreeEvent, 64),
+	}
+}
+
+func TestTreeCacheDrop(t *testing.T) {
+	c := newTestTreeCache("/test")
+	c.Events = make(chan TreeEvent, 1)
+	c.setData("/test", []byte("root"), 0)
+	c.setData("/test", []byte("root1"), 1)
+	c.setData("/test", []byte("root2"), 2)
+	if data, _ := c.Get("/test"); string(data) != "root2" || c.Dropped() != 2 {
+		t.Log(string(data), c.Dropped())
+		t.Fail()
+	}
+	if e := <-c.Events; e.Type != TreeNodeAdded {
+		t.Log(e)
+		t.Fail()
+	}
+}
+
+func TestTreeCacheUpdate(t *testing.T) {
+	c := newTestTreeCache("/test")
+	c.setData("/test", []byte("root"), 0)
+	c.setChildren("/test", []string{"a", "b"})
+	c.setData("/test/a", []byte("a"), 0)
+	c.setChildren("/test/a", []string{"c"})
+	c.setData("/test/a/c", []byte("c"), 0)
+	c.setData("/test/b", []byte("b"), 0)
+	c.setData("/test/b", []byte("b"), 0)
+	c.setData("/test/b", []byte("b1"), 1)
+
+	children, ok := c.Children("/test")
+	if !ok || len(children) != 2 || children[0] != "a" || children[1] != "b" {
+		t.Log(children)
+		t.FailNow()
+	}
+	data, ok := c.Get("/test/b")
+	if !ok || string(data) != "b1" {
+		t.FailNow()
+	}
+
+	c.setChildren("/test", []string{"b"})
+	if _, ok := c.Get("/test/a/c"); ok {
+		t.FailNow()
+	}
+	if len(c.Snapshot()) != 2 {
+		t.Log(c.Snapshot())
+		t.FailNow()
+	}
+
+	expect := []TreeEvent{
+		{TreeNodeAdded, "/test", []byte("root")},
+		{TreeNodeAdded, "/test/a", []byte("a")},
+		{TreeNodeAdded, "/test/a/c", []byte("c")},
+		{TreeNodeAdded, "/test/b", []byte("b")},
+		{TreeNodeUpdated, "/test/b", []byte("b1")},
+		{TreeNodeRemoved, "/test/a/c", []byte("c")},
+		{TreeNodeRemoved, "/test/a", []byte("a")},
+	}
+	if len(c.Events) != len(expect) {
+		t.Log(len(c.Events))
+		t.FailNow()
+	}
+	for _, e := range expect {
+		got := <-c.Events
+		if got.Type != e.Type || got.Path != e.Path || string(got.Data) != string(e.Data) {
+			t.Log(got.Type, got.Path, string(got.Data))
+			t.Fail()
+		}
+	}
+}
+
+func TestTreeCache(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	root := "/test/tree"
+	err := cli.CreatePersistNode(root)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer cli.DeleteNode(root)
+
+	c := NewTreeCache(cli, root)
+	defer c.Close()
+	e := <-c.Events
+	if e.Type != TreeNodeAdded || e.Path != root {
+		t.Log(e)
+		t.FailNow()
+	}
+	e = <-c.Events
+	if e.Type != TreeInitialized {
+		t.Log(e)
+		t.FailNow()
+	}
+
+	_, err = cli.CreateWithData(root+"/node", []byte("v1"), ModeEphemeral)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	e = <-c.Events
+	if e.Type != TreeNodeAdded || e.Path != root+"/node" || string(e.Data) != "v1" {
+		t.Log(e)
+		t.FailNow()
+	}
+
+	cli.SetData(root+"/node", []byte("v2"))
+	e = <-c.Events
+	if e.Type != TreeNodeUpdated || string(e.Data) != "v2" {
+		t.Log(e)
+		t.FailNow()
+	}
+	data, ok := c.Get(root + "/node")
+	if !ok || string(data) != "v2" {
+		t.FailNow()
+	}
+
+	cli.DeleteNode(root + "/node")
+	e = <-c.Events
+	if e.Type != TreeNodeRemoved || e.Path != root+"/node" {
+		t.Log(e)
+		t.FailNow()
+	}
+}
+
+// wait for event of type on npath, skip other events
+func waitTreeEvent(t *testing.T, c *TreeCache, typ TreeEventType, npath string) {
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case e := <-c.Events:
+			if e.Type == typ && e.Path == npath {
+				return
+			}
+		case <-timeout:
+			t.Log("wait tree event timeout", typ, npath)
+			t.FailNow()
+		}
+	}
+}
+
+func TestTreeCacheSessionExpire(t *testing.T) {
+	if testSrv == nil {
+		t.Skip("session expiry needs in-memory server")
+	}
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	root := "/test/tree-expire"
+	if err := cli.CreatePersistNode(root + "/persist"); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer cli.DeleteNode(root)
+	defer cli.DeleteNode(root + "/persist")
+	if _, err := cli.CreateWithData(root+"/ephemeral", nil, ModeEphemeral); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	states := cli.WatchSessionState()
+	defer states.Close()
+
+	c := NewTreeCache(cli, root)
+	defer c.Close()
+	waitTreeEvent(t, c, TreeInitialized, root)
+
+	testSrv.ExpireAllSessions()
+	for ev := range states.States {
+		if ev.State == StateHasSession {
+			break
+		}
+	}
+	waitTreeEvent(t, c, TreeNodeRemoved, root+"/ephemeral")
+
+	// watches are placed again by resync
+	cli.SetData(root+"/persist", []byte("v1"))
+	waitTreeEvent(t, c, TreeNodeUpdated, root+"/persist")
+	cli.SetData(root+"/persist", []byte("v2"))
+	waitTreeEvent(t, c, TreeNodeUpdated, root+"/persist")
+	if data, _ := c.Get(root + "/persist"); string(data) != "v2" {
+		t.Log(string(data))
+		t.Fail()
+	}
+	cli.CreateWithData(root+"/new", nil, ModeEphemeral)
+	waitTreeEvent(t, c, TreeNodeAdded, root+"/new")
+}
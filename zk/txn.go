@@ -0,0 +1,156 @@
+package zk
+
+import (
+	"fmt"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+type OpType int
+
+const (
+	OpCreate OpType = iota
+	OpDelete
+	OpSetData
+	OpCheck
+)
+
+func (t OpType) String() string {
+	switch t {
+	case OpCreate:
+		return "create"
+	case OpDelete:
+		return "delete"
+	case OpSetData:
+		return "setData"
+	case OpCheck:
+		return "check"
+	}
+	return "unknown"
+}
+
+// result of one operation in committed transaction
+type OpResult struct {
+	Type OpType
+	// client path, created path has sequence suffix in sequential mode
+	Path string
+	// new data version of setData
+	Version int32
+}
+
+// error of the first failed operation, no change is applied
+type TxnError struct {
+	Index int
+	Type  OpType
+	Path  string
+	Err   error
+}
+
+func (e *TxnError) Error() string {
+	return fmt.Sprintf("zk txn op %d %s %s: %v", e.Index, e.Type, e.Path, e.Err)
+}
+
+// multi operations applied atomically, build by ZKClient.Txn and chained calls:
+//
+//	cli.Txn().Create(p, data, ModePersistent).SetData(counter, n, version).Commit()
+//
+// parent nodes are not created, version -1 matches any version
+type Txn struct {
+	cli   *ZKClient
+	types []OpType
+	paths []string
+	ops   []interface{}
+	err   error
+}
+
+func (cli *ZKClient) Txn() *Txn {
+	return &Txn{cli: cli}
+}
+
+func (t *Txn) add(typ OpType, npath string, op func(fpath string) interface{}) *Txn {
+	if t.err != nil {
+		return t
+	}
+	fpath, err := t.cli.fullPath(npath)
+	if err != nil {
+		t.err = &TxnError{Index: len(t.ops), Type: typ, Path: npath, Err: err}
+		return t
+	}
+	t.types = append(t.types, typ)
+	t.paths = append(t.paths, npath)
+	t.ops = append(t.ops, op(fpath))
+	return t
+}
+
+func (t *Txn) Create(npath string, data []byte, mode CreateMode) *Txn {
+	return t.CreateWithACL(npath, data, mode, t.cli.nodeACL())
+}
+
+func (t *Txn) CreateWithACL(npath string, data []byte, mode CreateMode, acl []zk.ACL) *Txn {
+	return t.add(OpCreate, npath, func(fpath string) interface{} {
+		return &zk.CreateRequest{Path: fpath, Data: data, Acl: acl, Flags: int32(mode)}
+	})
+}
+
+func (t *Txn) Delete(npath string, version int32) *Txn {
+	return t.add(OpDelete, npath, func(fpath string) interface{} {
+		return &zk.DeleteRequest{Path: fpath, Version: version}
+	})
+}
+
+func (t *Txn) SetData(npath string, data []byte, version int32) *Txn {
+	return t.add(OpSetData, npath, func(fpath string) interface{} {
+		return &zk.SetDataRequest{Path: fpath, Data: data, Version: version}
+	})
+}
+
+// check node data version is not modified
+func (t *Txn) Check(npath string, version int32) *Txn {
+	return t.add(OpCheck, npath, func(fpath string) interface{} {
+		return &zk.CheckVersionRequest{Path: fpath, Version: version}
+	})
+}
+
+// commit all operations, return results in order of operations.
+// return *TxnError if any operation fails, zk.ErrBadVersion is reported as ErrVersionConflict
+func (t *Txn) Commit() ([]OpResult, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	if len(t.ops) == 0 {
+		return nil, nil
+	}
+	conn := t.cli.getConn()
+	if conn == nil {
+		return nil, ErrNoZkConnection
+	}
+	resps, err := conn.Multi(t.ops...)
+	for i, resp := range resps {
+		if i >= len(t.ops) || resp.Error == nil {
+			continue
+		}
+		if resp.Error == zk.ErrBadVersion {
+			resp.Error = ErrVersionConflict
+		}
+		return nil, &TxnError{Index: i, Type: t.types[i], Path: t.paths[i], Err: resp.Error}
+	}
+	if err != nil {
+		return nil, err
+	}
+	results := make([]OpResult, len(t.ops))
+	for i := range t.ops {
+		results[i] = OpResult{Type: t.types[i], Path: t.paths[i]}
+		if i >= len(resps) {
+			continue
+		}
+		switch t.types[i] {
+		case OpCreate:
+			results[i].Path = t.cli.clientPath(resps[i].String)
+		case OpSetData:
+			if resps[i].Stat != nil {
+				results[i].Version = resps[i].Stat.Version
+			}
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,70 @@
+package zk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestTxn(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	dir := "/test/txn"
+	counter := dir + "/counter"
+	if _, err := cli.CreateWithData(counter, []byte("0"), ModePersistent); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer cli.DeleteNode(dir)
+	defer cli.DeleteNode(counter)
+
+	results, err := cli.Txn().
+		Create(dir+"/node-", []byte("a"), ModeEphemeralSequential).
+		Check(counter, 0).
+		SetData(counter, []byte("1"), 0).
+		Commit()
+	if err != nil || len(results) != 3 {
+		t.Log(results, err)
+		t.FailNow()
+	}
+	if results[0].Type != OpCreate || results[0].Path != dir+"/node-0000000001" {
+		t.Log(results[0])
+		t.Fail()
+	}
+	if results[2].Type != OpSetData || results[2].Version != 1 {
+		t.Log(results[2])
+		t.Fail()
+	}
+	defer cli.DeleteNode(results[0].Path)
+
+	_, err = cli.Txn().
+		Create(dir+"/other", nil, ModePersistent).
+		SetData(counter, []byte("2"), 0).
+		Delete(results[0].Path, anyVersion).
+		Commit()
+	txnErr, ok := err.(*TxnError)
+	if !ok || txnErr.Index != 1 || txnErr.Type != OpSetData || txnErr.Err != ErrVersionConflict {
+		t.Log(err)
+		t.FailNow()
+	}
+	if exist, _ := cli.NodeExist(dir + "/other"); exist {
+		t.Log("txn not rolled back")
+		t.Fail()
+	}
+	if data, _, _ := cli.GetData(counter); string(data) != "1" {
+		t.Log(string(data))
+		t.Fail()
+	}
+
+	_, err = cli.Txn().Check(dir+"/none", anyVersion).Commit()
+	if txnErr, ok := err.(*TxnError); !ok || txnErr.Err != zk.ErrNoNode {
+		t.Log(err)
+		t.Fail()
+	}
+	_, err = cli.Txn().Delete("/a/../b", anyVersion).Commit()
+	if txnErr, ok := err.(*TxnError); !ok || txnErr.Err != ErrInvalidPath {
+		t.Log(err)
+		t.Fail()
+	}
+}
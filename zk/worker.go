@@ -0,0 +1,277 @@
+package zk
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/alecthomas/log4go"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// lease unique worker id, such as snowflake machine id, on protected ephemeral sequential nodes under dir.
+// contenders claim id one by one in order of node sequence, the claimed id is saved as node data,
+// and the lowest id not claimed by others is taken. id is free again once the node is gone.
+// lease is paused while session disconnected, and lost once session expired or disconnected
+// longer than session timeout, because session may expire unnoticed and the id may be leased by others.
+// WorkerLease implements snowflake.PausableLease.
+
+const workerPrefix = "worker-"
+
+var (
+	ErrWorkerIDExhausted = errors.New("zk worker id exhausted")
+	ErrLeaseLost         = errors.New("zk worker id lease lost")
+)
+
+type WorkerIDAllocator struct {
+	cli   *ZKClient
+	dir   string
+	maxID int64
+}
+
+// id is in [0, maxID]
+func NewWorkerIDAllocator(cli *ZKClient, dir string, maxID int64) *WorkerIDAllocator {
+	return &WorkerIDAllocator{
+		cli:   cli,
+		dir:   JoinPath(dir),
+		maxID: maxID,
+	}
+}
+
+// block until id claimed or ctx done, call WorkerLease.Release after use
+func (a *WorkerIDAllocator) Allocate(ctx context.Context) (*WorkerLease, error) {
+	// watch session before creating node, disconnection during claiming is not missed
+	sw := a.cli.WatchSessionState()
+	node, err := a.cli.createProtected(a.dir, workerPrefix, newProtectedID(), nil, false)
+	if err != nil {
+		sw.Close()
+		return nil, err
+	}
+	id, err := a.claim(ctx, node)
+	if err != nil {
+		sw.Close()
+		a.cli.DeleteNode(node)
+		return nil, err
+	}
+	l := &WorkerLease{
+		id:      id,
+		node:    node,
+		cli:     a.cli,
+		mutex:   &sync.Mutex{},
+		active:  make(chan struct{}),
+		lost:    make(chan struct{}),
+		release: make(chan struct{}),
+		exited:  make(chan struct{}),
+	}
+	close(l.active)
+	sched := make(chan struct{})
+	go func() {
+		close(sched)
+		l.watch(sw)
+	}()
+	<-sched
+	return l, nil
+}
+
+func (a *WorkerIDAllocator) claim(ctx context.Context, node string) (int64, error) {
+	_, name := SplitPath(node)
+	for {
+		children, err := a.cli.GetChildren(a.dir)
+		if err != nil {
+			return 0, err
+		}
+		nodes := sortBySequence(children)
+		used := make(map[int64]bool, len(nodes))
+		pending, found := "", false
+		for _, n := range nodes {
+			if n == name {
+				found = true
+				continue
+			}
+			data, _, err := a.cli.GetData(JoinPath(a.dir, n))
+			if err == zk.ErrNoNode {
+				continue
+			}
+			if err != nil {
+				return 0, err
+			}
+			if len(data) > 0 {
+				id, err := strconv.ParseInt(string(data), 10, 64)
+				if err != nil {
+					log.Error("worker node:%s invalid id:%s", n, data)
+					continue
+				}
+				used[id] = true
+			} else if !found {
+				pending = n
+			}
+		}
+		if !found {
+			return 0, ErrLeaseLost
+		}
+
+		if pending != "" {
+			// wait for the last predecessor still claiming
+			data, _, ev, err := a.cli.getW(JoinPath(a.dir, pending))
+			if err == zk.ErrNoNode || (err == nil && len(data) > 0) {
+				continue
+			}
+			if err != nil {
+				return 0, err
+			}
+			select {
+			case <-ev:
+				continue
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			}
+		}
+
+		for id := int64(0); id <= a.maxID; id++ {
+			if used[id] {
+				continue
+			}
+			if err := a.cli.SetData(node, []byte(strconv.FormatInt(id, 10))); err != nil {
+				return 0, err
+			}
+			return id, nil
+		}
+		return 0, ErrWorkerIDExhausted
+	}
+}
+
+type WorkerLease struct {
+	id      int64
+	node    string
+	cli     *ZKClient
+	once    sync.Once
+	mutex   *sync.Mutex
+	paused  bool
+	active  chan struct{} // closed while not paused
+	lost    chan struct{}
+	release chan struct{}
+	exited  chan struct{}
+}
+
+func (l *WorkerLease) ID() int64 {
+	return l.id
+}
+
+// closed once lease is lost or released
+func (l *WorkerLease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// closed while lease is not paused, id must not be used until it is closed.
+// it is never closed if lease is lost while paused, so wait Lost as well
+func (l *WorkerLease) Active() <-chan struct{} {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.active
+}
+
+func (l *WorkerLease) pause() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.paused {
+		l.paused = true
+		l.active = make(chan struct{})
+	}
+}
+
+func (l *WorkerLease) resume() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.paused {
+		l.paused = false
+		close(l.active)
+	}
+}
+
+// delete lease node, id may be leased by others after release
+func (l *WorkerLease) Release() error {
+	l.once.Do(func() {
+		close(l.release)
+	})
+	<-l.exited
+	err := l.cli.DeleteNode(l.node)
+	if err == zk.ErrNoNode {
+		return nil
+	}
+	return err
+}
+
+func (l *WorkerLease) watch(sw *StateWatcher) {
+	defer close(l.exited)
+	defer sw.Close()
+	defer close(l.lost)
+
+	var ev <-chan zk.Event
+	// not nil while disconnected
+	var expire *time.Timer
+	var expired <-chan time.Time
+	defer func() {
+		if expire != nil {
+			expire.Stop()
+		}
+	}()
+	disconnect := func(reason interface{}) {
+		if expired != nil {
+			return
+		}
+		log.Warn("worker node:%s lease paused, %v", l.node, reason)
+		l.pause()
+		if expire == nil {
+			expire = time.NewTimer(l.cli.SessionTimeout)
+		} else {
+			expire.Reset(l.cli.SessionTimeout)
+		}
+		expired = expire.C
+	}
+	for {
+		if ev == nil && expired == nil {
+			exist, w, err := l.cli.existsW(l.node)
+			if err == zk.ErrConnectionClosed || err == zk.ErrNoServer {
+				// watch again once session rebuilt
+				disconnect(err)
+			} else if err != nil || !exist {
+				log.Error("watch worker node:%s fail, exist:%t error:%v", l.node, exist, err)
+				return
+			}
+			ev = w
+		}
+		select {
+		case e := <-ev:
+			if e.Type != zk.EventNodeDataChanged {
+				log.Error("worker node:%s recv event:%s", l.node, e)
+				return
+			}
+			ev = nil
+		case s := <-sw.States:
+			switch s.State {
+			case StateDisconnected:
+				disconnect(s.State)
+			case StateHasSession:
+				// event may be queued before disconnection
+				if expired == nil || l.cli.State() != StateHasSession {
+					continue
+				}
+				expire.Stop()
+				expired = nil
+				log.Info("worker node:%s lease resumed", l.node)
+				l.resume()
+			case StateExpired:
+				log.Error("worker node:%s lease lost, session:%s", l.node, s.State)
+				return
+			}
+		case <-expired:
+			log.Error("worker node:%s lease lost, disconnected longer than session timeout", l.node)
+			return
+		case <-l.release:
+			return
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package zk
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerIDAllocator(t *testing.T) {
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	dir := "/test/worker"
+	defer cli.DeleteNode(dir)
+
+	n := 5
+	leases := make([]*WorkerLease, n)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l, err := NewWorkerIDAllocator(cli, dir, int64(n-1)).Allocate(context.Background())
+			if err != nil {
+				t.Log(err)
+				t.Fail()
+				return
+			}
+			leases[i] = l
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+	ids := make(map[int64]bool)
+	for _, l := range leases {
+		ids[l.ID()] = true
+	}
+	if len(ids) != n {
+		t.Log(ids)
+		t.FailNow()
+	}
+
+	a := NewWorkerIDAllocator(cli, dir, int64(n-1))
+	if _, err := a.Allocate(context.Background()); err != ErrWorkerIDExhausted {
+		t.Log(err)
+		t.Fail()
+	}
+	released := leases[2].ID()
+	leases[2].Release()
+	select {
+	case <-leases[2].Lost():
+	default:
+		t.Fail()
+	}
+	l, err := a.Allocate(context.Background())
+	if err != nil || l.ID() != released {
+		t.Log(err)
+		t.Fail()
+	}
+	leases[2] = l
+	for _, l := range leases {
+		l.Release()
+	}
+}
+
+func TestWorkerLeaseLost(t *testing.T) {
+	if testSrv == nil {
+		t.Skip("session expiry needs in-memory server")
+	}
+	cli := NewZKClient(testServers, time.Second*5, nil)
+	defer cli.Close()
+	dir := "/test/workerlost"
+	defer cli.DeleteNode(dir)
+
+	l, err := NewWorkerIDAllocator(cli, dir, 0).Allocate(context.Background())
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer l.Release()
+	testSrv.ExpireAllSessions()
+	select {
+	case <-l.Lost():
+	case <-time.After(time.Second * 5):
+		t.Log("lease not lost")
+		t.Fail()
+	}
+}
+
+func TestWorkerLeasePaused(t *testing.T) {
+	if testSrv == nil {
+		t.Skip("dropping connections needs in-memory server")
+	}
+	var refuse int32
+	cli := NewZKClient(testServers, time.Second*4, func(network, address string, timeout time.Duration) (net.Conn, error) {
+		if atomic.LoadInt32(&refuse) == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return net.DialTimeout(network, address, timeout)
+	})
+	defer cli.Close()
+	dir := "/test/workerpaused"
+	defer cli.DeleteNode(dir)
+
+	l, err := NewWorkerIDAllocator(cli, dir, 0).Allocate(context.Background())
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer l.Release()
+	if _, name := SplitPath(l.node); !strings.HasPrefix(name, protectedPrefix) {
+		t.Log(name)
+		t.Fail()
+	}
+
+	// reconnect in session timeout
+	atomic.StoreInt32(&refuse, 1)
+	testSrv.DropConnections()
+	var active <-chan struct{}
+	for i := 0; i < 100; i++ {
+		active = l.Active()
+		select {
+		case <-active:
+			time.Sleep(time.Millisecond * 10)
+			continue
+		default:
+		}
+		break
+	}
+	select {
+	case <-active:
+		t.Log("lease not paused")
+		t.FailNow()
+	default:
+	}
+	atomic.StoreInt32(&refuse, 0)
+	select {
+	case <-active:
+	case <-l.Lost():
+		t.Log("lease lost")
+		t.FailNow()
+	case <-time.After(time.Second * 5):
+		t.Log("lease not resumed")
+		t.FailNow()
+	}
+
+	// disconnected longer than session timeout
+	atomic.StoreInt32(&refuse, 1)
+	testSrv.DropConnections()
+	select {
+	case <-l.Lost():
+	case <-time.After(time.Second * 8):
+		t.Log("lease not lost")
+		t.Fail()
+	}
+	atomic.StoreInt32(&refuse, 0)
+}
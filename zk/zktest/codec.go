@@ -0,0 +1,177 @@
+package zktest
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+var errShortPacket = errors.New("zktest: short packet")
+
+// encode packet in jute format
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) int32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) int64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *encoder) bytes(v []byte) {
+	if v == nil {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) string(v string) {
+	e.int32(int32(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) strings(v []string) {
+	e.int32(int32(len(v)))
+	for _, s := range v {
+		e.string(s)
+	}
+}
+
+func (e *encoder) stat(st *zk.Stat) {
+	e.int64(st.Czxid)
+	e.int64(st.Mzxid)
+	e.int64(st.Ctime)
+	e.int64(st.Mtime)
+	e.int32(st.Version)
+	e.int32(st.Cversion)
+	e.int32(st.Aversion)
+	e.int64(st.EphemeralOwner)
+	e.int32(st.DataLength)
+	e.int32(st.NumChildren)
+	e.int64(st.Pzxid)
+}
+
+func (e *encoder) acls(acl []zk.ACL) {
+	e.int32(int32(len(acl)))
+	for _, a := range acl {
+		e.int32(a.Perms)
+		e.string(a.Scheme)
+		e.string(a.ID)
+	}
+}
+
+// packet with length prefix
+func (e *encoder) packet() []byte {
+	pkt := make([]byte, 4+len(e.buf))
+	binary.BigEndian.PutUint32(pkt, uint32(len(e.buf)))
+	copy(pkt[4:], e.buf)
+	return pkt
+}
+
+// decode packet in jute format, first error is kept and later reads return zero value
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.buf) < n {
+		d.err = errShortPacket
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) int32() int32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) int64() int64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (d *decoder) bool() bool {
+	b := d.next(1)
+	if b == nil {
+		return false
+	}
+	return b[0] != 0
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if d.err != nil || n < 0 {
+		return nil
+	}
+	b := d.next(int(n))
+	if b == nil {
+		return nil
+	}
+	v := make([]byte, n)
+	copy(v, b)
+	return v
+}
+
+func (d *decoder) string() string {
+	n := d.int32()
+	b := d.next(int(n))
+	return string(b)
+}
+
+func (d *decoder) strings() []string {
+	n := d.int32()
+	if d.err != nil || n < 0 {
+		return nil
+	}
+	v := make([]string, 0)
+	for i := int32(0); i < n && d.err == nil; i++ {
+		v = append(v, d.string())
+	}
+	return v
+}
+
+func (d *decoder) acls() []zk.ACL {
+	n := d.int32()
+	if d.err != nil || n < 0 {
+		return nil
+	}
+	v := make([]zk.ACL, 0)
+	for i := int32(0); i < n && d.err == nil; i++ {
+		perms := d.int32()
+		scheme := d.string()
+		id := d.string()
+		v = append(v, zk.ACL{Perms: perms, Scheme: scheme, ID: id})
+	}
+	return v
+}
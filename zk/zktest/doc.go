@@ -0,0 +1,15 @@
+/*
+Package zktest provide an in-memory zookeeper server for tests.
+
+The server speaks enough zookeeper wire protocol for "github.com/samuel/go-zookeeper/zk",
+supporting create, delete, exists, get/set data, children, acl, multi, watches,
+ephemeral nodes and session expiry injection.
+
+	srv, err := zktest.NewServer()
+	if err != nil {
+		panic(err)
+	}
+	defer srv.Close()
+	cli := zk.NewZKClient(srv.Servers(), time.Second*5, nil)
+*/
+package zktest
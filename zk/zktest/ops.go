@@ -0,0 +1,758 @@
+package zktest
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	opCreate       = 1
+	opDelete       = 2
+	opExists       = 3
+	opGetData      = 4
+	opSetData      = 5
+	opGetAcl       = 6
+	opSetAcl       = 7
+	opGetChildren  = 8
+	opSync         = 9
+	opPing         = 11
+	opGetChildren2 = 12
+	opCheck        = 13
+	opMulti        = 14
+	opClose        = -11
+	opSetAuth      = 100
+	opSetWatches   = 101
+	opError        = -1
+)
+
+const (
+	errOk                      = 0
+	errRuntimeInconsistency    = -2
+	errMarshallingError        = -5
+	errUnimplemented           = -6
+	errBadArguments            = -8
+	errNoNode                  = -101
+	errNoAuth                  = -102
+	errBadVersion              = -103
+	errNoChildrenForEphemerals = -108
+	errNodeExists              = -110
+	errNotEmpty                = -111
+	errInvalidACL              = -114
+	errAuthFailed              = -115
+)
+
+const (
+	xidWatcherEvent    = -1
+	xidPing            = -2
+	stateSyncConnected = 3
+	anyVersion         = -1
+)
+
+type znode struct {
+	data     []byte
+	acl      []zk.ACL
+	stat     zk.Stat
+	children map[string]struct{}
+}
+
+func newZnode(data []byte, acl []zk.ACL, zxid int64, owner int64) *znode {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	return &znode{
+		data: data,
+		acl:  acl,
+		stat: zk.Stat{
+			Czxid:          zxid,
+			Mzxid:          zxid,
+			Ctime:          now,
+			Mtime:          now,
+			EphemeralOwner: owner,
+			DataLength:     int32(len(data)),
+			Pzxid:          zxid,
+		},
+		children: make(map[string]struct{}),
+	}
+}
+
+func (n *znode) clone() *znode {
+	c := *n
+	c.children = make(map[string]struct{}, len(n.children))
+	for k := range n.children {
+		c.children[k] = struct{}{}
+	}
+	return &c
+}
+
+type watchEvent struct {
+	typ  zk.EventType
+	path string
+}
+
+func validPath(p string) bool {
+	if p == "/" {
+		return true
+	}
+	if !strings.HasPrefix(p, "/") || strings.HasSuffix(p, "/") || strings.ContainsRune(p, 0) {
+		return false
+	}
+	for _, part := range strings.Split(p[1:], "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func splitPath(p string) (string, string) {
+	i := strings.LastIndex(p, "/")
+	if i == 0 {
+		return "/", p[1:]
+	}
+	return p[:i], p[i+1:]
+}
+
+func digestID(auth string) string {
+	idx := strings.Index(auth, ":")
+	if idx < 0 {
+		return ""
+	}
+	h := sha1.Sum([]byte(auth))
+	return auth[:idx] + ":" + base64.StdEncoding.EncodeToString(h[:])
+}
+
+func (c *conn) remoteIP() net.IP {
+	if addr, ok := c.nc.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
+
+func (c *conn) matchID(scheme, id string) bool {
+	switch scheme {
+	case "world":
+		return id == "anyone"
+	case "ip":
+		ip := c.remoteIP()
+		if ip == nil {
+			return false
+		}
+		if _, ipnet, err := net.ParseCIDR(id); err == nil {
+			return ipnet.Contains(ip)
+		}
+		return ip.Equal(net.ParseIP(id))
+	}
+	for _, a := range c.authIDs {
+		if a.Scheme == scheme && a.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *conn) allowed(acl []zk.ACL, perm int32) bool {
+	for _, a := range acl {
+		if a.Perms&perm != 0 && c.matchID(a.Scheme, a.ID) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate acl and replace auth scheme with authenticated ids
+func (c *conn) fixupACL(acl []zk.ACL) ([]zk.ACL, int32) {
+	if len(acl) == 0 {
+		return nil, errInvalidACL
+	}
+	fixed := make([]zk.ACL, 0, len(acl))
+	for _, a := range acl {
+		switch a.Scheme {
+		case "world":
+			if a.ID != "anyone" {
+				return nil, errInvalidACL
+			}
+		case "digest":
+			if !strings.Contains(a.ID, ":") {
+				return nil, errInvalidACL
+			}
+		case "ip":
+			if net.ParseIP(a.ID) == nil {
+				if _, _, err := net.ParseCIDR(a.ID); err != nil {
+					return nil, errInvalidACL
+				}
+			}
+		case "auth":
+			if len(c.authIDs) == 0 {
+				return nil, errInvalidACL
+			}
+			for _, id := range c.authIDs {
+				fixed = append(fixed, zk.ACL{Perms: a.Perms, Scheme: id.Scheme, ID: id.ID})
+			}
+			continue
+		default:
+			return nil, errInvalidACL
+		}
+		fixed = append(fixed, a)
+	}
+	return fixed, errOk
+}
+
+// handle one request, return false if connection should be closed
+func (s *Server) handle(c *conn, buf []byte) bool {
+	d := &decoder{buf: buf}
+	xid := d.int32()
+	op := d.int32()
+	if d.err != nil {
+		return false
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.sessions[c.sess.id] != c.sess {
+		return false
+	}
+
+	var (
+		resp      *encoder
+		code      int32
+		closeConn bool
+	)
+	switch op {
+	case opPing:
+		xid = xidPing
+	case opClose:
+		s.closeSession(c.sess)
+		closeConn = true
+	case opSetAuth:
+		code = c.setAuth(d)
+	case opSetWatches:
+		s.setWatches(c, d)
+	case opMulti:
+		resp, code = s.multi(c, d)
+	default:
+		resp, code = s.do(c, op, d)
+	}
+	if d.err != nil {
+		resp, code = nil, errMarshallingError
+	}
+
+	e := &encoder{}
+	e.int32(xid)
+	e.int64(s.zxid)
+	if code != errOk {
+		e.int32(code)
+	} else {
+		e.int32(errOk)
+		if resp != nil {
+			e.buf = append(e.buf, resp.buf...)
+		}
+	}
+	c.send(e.packet())
+	if closeConn {
+		c.send(nil)
+		return false
+	}
+	return true
+}
+
+// run single operation
+func (s *Server) do(c *conn, op int32, d *decoder) (*encoder, int32) {
+	switch op {
+	case opCreate, opDelete, opSetData, opCheck:
+		t := decodeTxnOp(op, d)
+		if d.err != nil {
+			return nil, errMarshallingError
+		}
+		s.zxid++
+		events := make([]watchEvent, 0, 2)
+		resp, code := s.apply(c, t, &events)
+		s.fire(events)
+		return resp, code
+	case opExists:
+		return s.exists(c, d.string(), d.bool())
+	case opGetData:
+		return s.getData(c, d.string(), d.bool())
+	case opGetChildren:
+		return s.getChildren(c, d.string(), d.bool(), false)
+	case opGetChildren2:
+		return s.getChildren(c, d.string(), d.bool(), true)
+	case opGetAcl:
+		return s.getACL(d.string())
+	case opSetAcl:
+		return s.setACL(c, d.string(), d.acls(), d.int32())
+	case opSync:
+		e := &encoder{}
+		e.string(d.string())
+		return e, errOk
+	}
+	return nil, errUnimplemented
+}
+
+func (c *conn) setAuth(d *decoder) int32 {
+	d.int32() // auth type
+	scheme := d.string()
+	auth := d.bytes()
+	if d.err != nil {
+		return errMarshallingError
+	}
+	if scheme != "digest" {
+		return errAuthFailed
+	}
+	id := digestID(string(auth))
+	if id == "" {
+		return errAuthFailed
+	}
+	if !c.matchID(scheme, id) {
+		c.authIDs = append(c.authIDs, zk.ACL{Scheme: scheme, ID: id})
+	}
+	return errOk
+}
+
+// write operation in request or multi request
+type txnOp struct {
+	op      int32
+	path    string
+	data    []byte
+	acl     []zk.ACL
+	flags   int32
+	version int32
+}
+
+func decodeTxnOp(op int32, d *decoder) *txnOp {
+	t := &txnOp{op: op, path: d.string()}
+	switch op {
+	case opCreate:
+		t.data = d.bytes()
+		t.acl = d.acls()
+		t.flags = d.int32()
+	case opSetData:
+		t.data = d.bytes()
+		t.version = d.int32()
+	case opDelete, opCheck:
+		t.version = d.int32()
+	}
+	return t
+}
+
+func (s *Server) apply(c *conn, t *txnOp, events *[]watchEvent) (*encoder, int32) {
+	switch t.op {
+	case opCreate:
+		p, code := s.create(c, t.path, t.data, t.acl, t.flags, events)
+		if code != errOk {
+			return nil, code
+		}
+		e := &encoder{}
+		e.string(p)
+		return e, errOk
+	case opDelete:
+		return nil, s.delete(c, t.path, t.version, events)
+	case opSetData:
+		st, code := s.setData(c, t.path, t.data, t.version, events)
+		if code != errOk {
+			return nil, code
+		}
+		e := &encoder{}
+		e.stat(st)
+		return e, errOk
+	case opCheck:
+		return nil, s.check(c, t.path, t.version)
+	}
+	return nil, errUnimplemented
+}
+
+func (s *Server) create(c *conn, p string, data []byte, acl []zk.ACL, flags int32, events *[]watchEvent) (string, int32) {
+	sequential := flags&zk.FlagSequence != 0
+	if sequential && strings.HasSuffix(p, "/") {
+		// sequential node could be named by sequence only
+		if !validPath(p + "0") {
+			return "", errBadArguments
+		}
+	} else if !validPath(p) {
+		return "", errBadArguments
+	}
+	if p == "/" {
+		return "", errNodeExists
+	}
+	parentPath, _ := splitPath(p)
+	if sequential && strings.HasSuffix(p, "/") {
+		parentPath = strings.TrimSuffix(p, "/")
+		if parentPath == "" {
+			parentPath = "/"
+		}
+	}
+	parent, ok := s.nodes[parentPath]
+	if !ok {
+		return "", errNoNode
+	}
+	if parent.stat.EphemeralOwner != 0 {
+		return "", errNoChildrenForEphemerals
+	}
+	if !c.allowed(parent.acl, zk.PermCreate) {
+		return "", errNoAuth
+	}
+	acl, code := c.fixupACL(acl)
+	if code != errOk {
+		return "", code
+	}
+	if sequential {
+		p = fmt.Sprintf("%s%010d", p, parent.stat.Cversion)
+	}
+	if _, ok := s.nodes[p]; ok {
+		return "", errNodeExists
+	}
+
+	owner := int64(0)
+	if flags&zk.FlagEphemeral != 0 {
+		owner = c.sess.id
+	}
+	s.nodes[p] = newZnode(data, acl, s.zxid, owner)
+	_, name := splitPath(p)
+	parent.children[name] = struct{}{}
+	parent.stat.Cversion++
+	parent.stat.NumChildren = int32(len(parent.children))
+	parent.stat.Pzxid = s.zxid
+	*events = append(*events,
+		watchEvent{zk.EventNodeCreated, p},
+		watchEvent{zk.EventNodeChildrenChanged, parentPath})
+	return p, errOk
+}
+
+func (s *Server) delete(c *conn, p string, version int32, events *[]watchEvent) int32 {
+	if !validPath(p) || p == "/" {
+		return errBadArguments
+	}
+	n, ok := s.nodes[p]
+	if !ok {
+		return errNoNode
+	}
+	parentPath, _ := splitPath(p)
+	if !c.allowed(s.nodes[parentPath].acl, zk.PermDelete) {
+		return errNoAuth
+	}
+	if version != anyVersion && version != n.stat.Version {
+		return errBadVersion
+	}
+	if len(n.children) > 0 {
+		return errNotEmpty
+	}
+	s.deleteNode(p, events)
+	return errOk
+}
+
+// delete node without check, node should exist
+func (s *Server) deleteNode(p string, events *[]watchEvent) {
+	delete(s.nodes, p)
+	parentPath, name := splitPath(p)
+	parent := s.nodes[parentPath]
+	delete(parent.children, name)
+	parent.stat.Cversion++
+	parent.stat.NumChildren = int32(len(parent.children))
+	parent.stat.Pzxid = s.zxid
+	*events = append(*events,
+		watchEvent{zk.EventNodeDeleted, p},
+		watchEvent{zk.EventNodeChildrenChanged, parentPath})
+}
+
+func (s *Server) setData(c *conn, p string, data []byte, version int32, events *[]watchEvent) (*zk.Stat, int32) {
+	if !validPath(p) {
+		return nil, errBadArguments
+	}
+	n, ok := s.nodes[p]
+	if !ok {
+		return nil, errNoNode
+	}
+	if !c.allowed(n.acl, zk.PermWrite) {
+		return nil, errNoAuth
+	}
+	if version != anyVersion && version != n.stat.Version {
+		return nil, errBadVersion
+	}
+	n.data = data
+	n.stat.Version++
+	n.stat.Mzxid = s.zxid
+	n.stat.Mtime = time.Now().UnixNano() / int64(time.Millisecond)
+	n.stat.DataLength = int32(len(data))
+	*events = append(*events, watchEvent{zk.EventNodeDataChanged, p})
+	st := n.stat
+	return &st, errOk
+}
+
+func (s *Server) check(c *conn, p string, version int32) int32 {
+	if !validPath(p) {
+		return errBadArguments
+	}
+	n, ok := s.nodes[p]
+	if !ok {
+		return errNoNode
+	}
+	if !c.allowed(n.acl, zk.PermRead) {
+		return errNoAuth
+	}
+	if version != anyVersion && version != n.stat.Version {
+		return errBadVersion
+	}
+	return errOk
+}
+
+func (s *Server) exists(c *conn, p string, watch bool) (*encoder, int32) {
+	if !validPath(p) {
+		return nil, errBadArguments
+	}
+	if watch {
+		// watch created event if node not exist, data changed or deleted event otherwise
+		addWatch(s.dataWatches, p, c)
+	}
+	n, ok := s.nodes[p]
+	if !ok {
+		return nil, errNoNode
+	}
+	e := &encoder{}
+	e.stat(&n.stat)
+	return e, errOk
+}
+
+func (s *Server) getData(c *conn, p string, watch bool) (*encoder, int32) {
+	if !validPath(p) {
+		return nil, errBadArguments
+	}
+	n, ok := s.nodes[p]
+	if !ok {
+		return nil, errNoNode
+	}
+	if !c.allowed(n.acl, zk.PermRead) {
+		return nil, errNoAuth
+	}
+	if watch {
+		addWatch(s.dataWatches, p, c)
+	}
+	e := &encoder{}
+	e.bytes(n.data)
+	e.stat(&n.stat)
+	return e, errOk
+}
+
+func (s *Server) getChildren(c *conn, p string, watch bool, withStat bool) (*encoder, int32) {
+	if !validPath(p) {
+		return nil, errBadArguments
+	}
+	n, ok := s.nodes[p]
+	if !ok {
+		return nil, errNoNode
+	}
+	if !c.allowed(n.acl, zk.PermRead) {
+		return nil, errNoAuth
+	}
+	if watch {
+		addWatch(s.childWatches, p, c)
+	}
+	children := make([]string, 0, len(n.children))
+	for name := range n.children {
+		children = append(children, name)
+	}
+	sort.Strings(children)
+	e := &encoder{}
+	e.strings(children)
+	if withStat {
+		e.stat(&n.stat)
+	}
+	return e, errOk
+}
+
+func (s *Server) getACL(p string) (*encoder, int32) {
+	if !validPath(p) {
+		return nil, errBadArguments
+	}
+	n, ok := s.nodes[p]
+	if !ok {
+		return nil, errNoNode
+	}
+	e := &encoder{}
+	e.acls(n.acl)
+	e.stat(&n.stat)
+	return e, errOk
+}
+
+func (s *Server) setACL(c *conn, p string, acl []zk.ACL, version int32) (*encoder, int32) {
+	if !validPath(p) {
+		return nil, errBadArguments
+	}
+	n, ok := s.nodes[p]
+	if !ok {
+		return nil, errNoNode
+	}
+	if !c.allowed(n.acl, zk.PermAdmin) {
+		return nil, errNoAuth
+	}
+	if version != anyVersion && version != n.stat.Aversion {
+		return nil, errBadVersion
+	}
+	acl, code := c.fixupACL(acl)
+	if code != errOk {
+		return nil, code
+	}
+	s.zxid++
+	n.acl = acl
+	n.stat.Aversion++
+	e := &encoder{}
+	e.stat(&n.stat)
+	return e, errOk
+}
+
+// run operations atomically, all changes are rolled back if any operation fails
+func (s *Server) multi(c *conn, d *decoder) (*encoder, int32) {
+	ops := make([]*txnOp, 0)
+	for d.err == nil {
+		typ := d.int32()
+		done := d.bool()
+		d.int32() // err
+		if done {
+			break
+		}
+		switch typ {
+		case opCreate, opDelete, opSetData, opCheck:
+			ops = append(ops, decodeTxnOp(typ, d))
+		default:
+			return nil, errUnimplemented
+		}
+	}
+	if d.err != nil {
+		return nil, errMarshallingError
+	}
+
+	zxid := s.zxid
+	backup := make(map[string]*znode, len(s.nodes))
+	for p, n := range s.nodes {
+		backup[p] = n.clone()
+	}
+	s.zxid++
+	events := make([]watchEvent, 0, len(ops)*2)
+	results := make([]*encoder, len(ops))
+	failed, failCode := -1, int32(errOk)
+	for i, t := range ops {
+		resp, code := s.apply(c, t, &events)
+		if code != errOk {
+			failed, failCode = i, code
+			break
+		}
+		results[i] = resp
+	}
+
+	e := &encoder{}
+	if failed >= 0 {
+		s.nodes = backup
+		s.zxid = zxid
+		for i := range ops {
+			code := int32(errOk)
+			if i == failed {
+				code = failCode
+			} else if i > failed {
+				code = errRuntimeInconsistency
+			}
+			e.int32(opError)
+			e.bool(false)
+			e.int32(code)
+			e.int32(code)
+		}
+	} else {
+		s.fire(events)
+		for i, t := range ops {
+			e.int32(t.op)
+			e.bool(false)
+			e.int32(errOk)
+			if results[i] != nil {
+				e.buf = append(e.buf, results[i].buf...)
+			}
+		}
+	}
+	e.int32(-1)
+	e.bool(true)
+	e.int32(-1)
+	return e, errOk
+}
+
+// reset watches after reconnection, event fires at once if node changed after relative zxid
+func (s *Server) setWatches(c *conn, d *decoder) {
+	relZxid := d.int64()
+	dataPaths := d.strings()
+	existPaths := d.strings()
+	childPaths := d.strings()
+	if d.err != nil {
+		return
+	}
+	for _, p := range dataPaths {
+		n, ok := s.nodes[p]
+		switch {
+		case !ok:
+			c.sendEvent(zk.EventNodeDeleted, p)
+		case n.stat.Mzxid > relZxid:
+			c.sendEvent(zk.EventNodeDataChanged, p)
+		default:
+			addWatch(s.dataWatches, p, c)
+		}
+	}
+	for _, p := range existPaths {
+		if _, ok := s.nodes[p]; ok {
+			c.sendEvent(zk.EventNodeCreated, p)
+		} else {
+			addWatch(s.dataWatches, p, c)
+		}
+	}
+	for _, p := range childPaths {
+		n, ok := s.nodes[p]
+		switch {
+		case !ok:
+			c.sendEvent(zk.EventNodeDeleted, p)
+		case n.stat.Pzxid > relZxid:
+			c.sendEvent(zk.EventNodeChildrenChanged, p)
+		default:
+			addWatch(s.childWatches, p, c)
+		}
+	}
+}
+
+func addWatch(watches map[string]map[*conn]struct{}, p string, c *conn) {
+	conns, ok := watches[p]
+	if !ok {
+		conns = make(map[*conn]struct{})
+		watches[p] = conns
+	}
+	conns[c] = struct{}{}
+}
+
+// trigger watches by events, each watch fires only once
+func (s *Server) fire(events []watchEvent) {
+	for _, ev := range events {
+		triggered := make(map[*conn]struct{})
+		if ev.typ != zk.EventNodeChildrenChanged {
+			for c := range s.dataWatches[ev.path] {
+				triggered[c] = struct{}{}
+			}
+			delete(s.dataWatches, ev.path)
+		}
+		if ev.typ == zk.EventNodeChildrenChanged || ev.typ == zk.EventNodeDeleted {
+			for c := range s.childWatches[ev.path] {
+				triggered[c] = struct{}{}
+			}
+			delete(s.childWatches, ev.path)
+		}
+		for c := range triggered {
+			c.sendEvent(ev.typ, ev.path)
+		}
+	}
+}
+
+func (c *conn) sendEvent(typ zk.EventType, p string) {
+	e := &encoder{}
+	e.int32(xidWatcherEvent)
+	e.int64(-1)
+	e.int32(errOk)
+	e.int32(int32(typ))
+	e.int32(stateSyncConnected)
+	e.string(p)
+	c.send(e.packet())
+}
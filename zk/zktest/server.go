@@ -0,0 +1,399 @@
+package zktest
+
+import (
+	"crypto/rand"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	protocolVersion   = 0
+	minSessionTimeout = 100 * time.Millisecond
+	maxPacketSize     = 4 * 1024 * 1024
+	connOutBufferSize = 4096
+	connectTimeout    = 10 * time.Second
+)
+
+type session struct {
+	id      int64
+	passwd  []byte
+	timeout time.Duration
+	conn    *conn       // nil if disconnected
+	timer   *time.Timer // expire timer when disconnected
+}
+
+// in-memory zookeeper server, all nodes and sessions are lost after Close
+type Server struct {
+	lock          *sync.Mutex
+	ln            net.Listener
+	closed        bool
+	wg            *sync.WaitGroup
+	zxid          int64
+	lastSessionID int64
+	nodes         map[string]*znode
+	sessions      map[int64]*session
+	conns         map[*conn]struct{}
+	dataWatches   map[string]map[*conn]struct{}
+	childWatches  map[string]map[*conn]struct{}
+}
+
+// start server listening on random local port
+func NewServer() (*Server, error) {
+	return NewServerAt("127.0.0.1:0")
+}
+
+func NewServerAt(addr string) (*Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		lock:          &sync.Mutex{},
+		ln:            ln,
+		wg:            &sync.WaitGroup{},
+		lastSessionID: time.Now().UnixNano() &^ 0xffffff,
+		nodes:         make(map[string]*znode),
+		sessions:      make(map[int64]*session),
+		conns:         make(map[*conn]struct{}),
+		dataWatches:   make(map[string]map[*conn]struct{}),
+		childWatches:  make(map[string]map[*conn]struct{}),
+	}
+	s.nodes["/"] = newZnode(nil, zk.WorldACL(zk.PermAll), 0, 0)
+	s.wg.Add(1)
+	go s.acceptLoop()
+	return s, nil
+}
+
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// server list used to connect
+func (s *Server) Servers() []string {
+	return []string{s.Addr()}
+}
+
+func (s *Server) Close() {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	s.closed = true
+	s.ln.Close()
+	for c := range s.conns {
+		c.close()
+	}
+	for _, sess := range s.sessions {
+		if sess.timer != nil {
+			sess.timer.Stop()
+		}
+	}
+	s.lock.Unlock()
+	s.wg.Wait()
+}
+
+// id of all alive sessions
+func (s *Server) Sessions() []int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ids := make([]int64, 0, len(s.sessions))
+	for id := range s.sessions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// expire session as session timeout, its ephemeral nodes are deleted and
+// client gets StateExpired on reconnection
+func (s *Server) ExpireSession(id int64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok {
+		return false
+	}
+	s.expireSession(sess)
+	return true
+}
+
+func (s *Server) ExpireAllSessions() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, sess := range s.sessions {
+		s.expireSession(sess)
+	}
+}
+
+// close all client connections but keep sessions, client reconnects to the same session
+func (s *Server) DropConnections() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for c := range s.conns {
+		c.close()
+	}
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			nc.Close()
+			return
+		}
+		c := newConn(s, nc)
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.lock.Unlock()
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+		}()
+	}
+}
+
+// handle connect request, return nil if session is expired or invalid
+func (s *Server) attachSession(c *conn, sessionID int64, passwd []byte, timeout time.Duration) *session {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return nil
+	}
+	if timeout < minSessionTimeout {
+		timeout = minSessionTimeout
+	}
+	if sessionID == 0 {
+		s.lastSessionID++
+		sess := &session{
+			id:      s.lastSessionID,
+			passwd:  make([]byte, 16),
+			timeout: timeout,
+			conn:    c,
+		}
+		rand.Read(sess.passwd)
+		s.sessions[sess.id] = sess
+		return sess
+	}
+	sess, ok := s.sessions[sessionID]
+	if !ok || string(sess.passwd) != string(passwd) {
+		return nil
+	}
+	if sess.timer != nil {
+		sess.timer.Stop()
+		sess.timer = nil
+	}
+	if sess.conn != nil {
+		// session moved to new connection
+		sess.conn.close()
+	}
+	sess.conn = c
+	return sess
+}
+
+// connection lost, session expires if client does not reconnect in session timeout
+func (s *Server) detachConn(c *conn) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.conns, c)
+	s.removeWatches(c)
+	sess := c.sess
+	if sess == nil || sess.conn != c || s.sessions[sess.id] != sess {
+		return
+	}
+	sess.conn = nil
+	if s.closed {
+		return
+	}
+	sess.timer = time.AfterFunc(sess.timeout, func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.sessions[sess.id] == sess && sess.conn == nil {
+			s.expireSession(sess)
+		}
+	})
+}
+
+// remove session and its ephemeral nodes, lock should be held
+func (s *Server) expireSession(sess *session) {
+	s.closeSession(sess)
+	if sess.conn != nil {
+		sess.conn.close()
+		sess.conn = nil
+	}
+}
+
+func (s *Server) closeSession(sess *session) {
+	delete(s.sessions, sess.id)
+	if sess.timer != nil {
+		sess.timer.Stop()
+		sess.timer = nil
+	}
+	paths := make([]string, 0)
+	for p, n := range s.nodes {
+		if n.stat.EphemeralOwner == sess.id {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	if len(paths) == 0 {
+		return
+	}
+	s.zxid++
+	events := make([]watchEvent, 0, len(paths)*2)
+	for _, p := range paths {
+		s.deleteNode(p, &events)
+	}
+	s.fire(events)
+}
+
+func (s *Server) removeWatches(c *conn) {
+	for _, watches := range []map[string]map[*conn]struct{}{s.dataWatches, s.childWatches} {
+		for p, conns := range watches {
+			delete(conns, c)
+			if len(conns) == 0 {
+				delete(watches, p)
+			}
+		}
+	}
+}
+
+// client connection
+type conn struct {
+	srv       *Server
+	nc        net.Conn
+	sess      *session
+	authIDs   []zk.ACL // authenticated scheme and id, perms is unused
+	out       chan []byte
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+func newConn(s *Server, nc net.Conn) *conn {
+	return &conn{
+		srv:       s,
+		nc:        nc,
+		out:       make(chan []byte, connOutBufferSize),
+		closed:    make(chan struct{}),
+		closeOnce: &sync.Once{},
+	}
+}
+
+func (c *conn) close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.nc.Close()
+	})
+}
+
+func (c *conn) readPacket(timeout time.Duration) ([]byte, error) {
+	c.nc.SetReadDeadline(time.Now().Add(timeout))
+	var head [4]byte
+	if _, err := io.ReadFull(c.nc, head[:]); err != nil {
+		return nil, err
+	}
+	d := &decoder{buf: head[:]}
+	n := d.int32()
+	if n < 0 || n > maxPacketSize {
+		return nil, errShortPacket
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c.nc, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (c *conn) serve() {
+	defer c.srv.detachConn(c)
+	defer c.close()
+
+	buf, err := c.readPacket(connectTimeout)
+	if err != nil {
+		return
+	}
+	d := &decoder{buf: buf}
+	d.int32() // protocol version
+	d.int64() // last zxid seen
+	timeout := time.Duration(d.int32()) * time.Millisecond
+	sessionID := d.int64()
+	passwd := d.bytes()
+	if d.err != nil {
+		return
+	}
+
+	sess := c.srv.attachSession(c, sessionID, passwd, timeout)
+	e := &encoder{}
+	e.int32(protocolVersion)
+	if sess == nil {
+		e.int32(0)
+		e.int64(0)
+		e.bytes(make([]byte, 16))
+		c.nc.Write(e.packet())
+		return
+	}
+	c.sess = sess
+	e.int32(int32(sess.timeout / time.Millisecond))
+	e.int64(sess.id)
+	e.bytes(sess.passwd)
+	if _, err := c.nc.Write(e.packet()); err != nil {
+		return
+	}
+
+	go c.writeLoop()
+	for {
+		buf, err := c.readPacket(sess.timeout)
+		if err != nil {
+			return
+		}
+		if !c.srv.handle(c, buf) {
+			// wait reply of close request written
+			select {
+			case <-c.closed:
+			case <-time.After(time.Second):
+			}
+			return
+		}
+	}
+}
+
+// nil packet means close connection after previous packets written
+func (c *conn) writeLoop() {
+	for {
+		select {
+		case pkt := <-c.out:
+			if pkt == nil {
+				c.close()
+				return
+			}
+			if _, err := c.nc.Write(pkt); err != nil {
+				c.close()
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// send without blocking, close slow connection whose buffer is full.
+// server lock should be held to keep packets in order
+func (c *conn) send(pkt []byte) {
+	select {
+	case c.out <- pkt:
+	case <-c.closed:
+	default:
+		c.close()
+	}
+}
@@ -0,0 +1,297 @@
+package zktest
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func connect(t *testing.T, srv *Server) (*zk.Conn, <-chan zk.Event) {
+	conn, events, err := zk.Connect(srv.Servers(), time.Second*5, zk.WithLogInfo(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ev := range events {
+		if ev.State == zk.StateHasSession {
+			break
+		}
+	}
+	return conn, events
+}
+
+func waitEvent(t *testing.T, ch <-chan zk.Event, typ zk.EventType) {
+	select {
+	case ev := <-ch:
+		if ev.Type != typ {
+			t.Fatalf("expect event %s, got %s", typ, ev.Type)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatalf("wait event %s timeout", typ)
+	}
+}
+
+func TestNodeOps(t *testing.T) {
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	conn, _ := connect(t, srv)
+	defer conn.Close()
+
+	acl := zk.WorldACL(zk.PermAll)
+	if _, err := conn.Create("/a/b", nil, 0, acl); err != zk.ErrNoNode {
+		t.Fatal(err)
+	}
+	if p, err := conn.Create("/a", []byte("1"), 0, acl); err != nil || p != "/a" {
+		t.Fatal(p, err)
+	}
+	if _, err := conn.Create("/a", nil, 0, acl); err != zk.ErrNodeExists {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		p, err := conn.Create("/a/seq-", nil, zk.FlagSequence, acl)
+		if err != nil || p != "/a/seq-000000000"+string('0'+rune(i)) {
+			t.Fatal(p, err)
+		}
+	}
+
+	data, st, err := conn.Get("/a")
+	if err != nil || string(data) != "1" || st.NumChildren != 2 {
+		t.Fatal(string(data), st, err)
+	}
+	if _, err := conn.Set("/a", []byte("2"), st.Version+1); err != zk.ErrBadVersion {
+		t.Fatal(err)
+	}
+	if st, err = conn.Set("/a", []byte("2"), st.Version); err != nil || st.Version != 1 {
+		t.Fatal(st, err)
+	}
+
+	children, _, err := conn.Children("/a")
+	if err != nil || len(children) != 2 || children[0] != "seq-0000000000" {
+		t.Fatal(children, err)
+	}
+	if err := conn.Delete("/a", -1); err != zk.ErrNotEmpty {
+		t.Fatal(err)
+	}
+	for _, c := range children {
+		if err := conn.Delete("/a/"+c, -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := conn.Delete("/a", 0); err != zk.ErrBadVersion {
+		t.Fatal(err)
+	}
+	if err := conn.Delete("/a", -1); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := conn.Exists("/a"); ok || err != nil {
+		t.Fatal(ok, err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	conn, _ := connect(t, srv)
+	defer conn.Close()
+	acl := zk.WorldACL(zk.PermAll)
+
+	_, _, existCh, err := conn.ExistsW("/w")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, childCh, err := conn.ChildrenW("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Create("/w", nil, 0, acl)
+	waitEvent(t, existCh, zk.EventNodeCreated)
+	waitEvent(t, childCh, zk.EventNodeChildrenChanged)
+
+	_, _, dataCh, _ := conn.GetW("/w")
+	conn.Set("/w", []byte("1"), -1)
+	waitEvent(t, dataCh, zk.EventNodeDataChanged)
+
+	// watch is reset after reconnection
+	_, _, dataCh, _ = conn.GetW("/w")
+	srv.DropConnections()
+	time.Sleep(time.Millisecond * 100)
+	conn.Delete("/w", -1)
+	waitEvent(t, dataCh, zk.EventNodeDeleted)
+}
+
+func TestEphemeralAndExpire(t *testing.T) {
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	conn, events := connect(t, srv)
+	defer conn.Close()
+	other, _ := connect(t, srv)
+	defer other.Close()
+	acl := zk.WorldACL(zk.PermAll)
+
+	if _, err := conn.Create("/e", nil, zk.FlagEphemeral, acl); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Create("/e/c", nil, 0, acl); err != zk.ErrNoChildrenForEphemerals {
+		t.Fatal(err)
+	}
+	_, _, ch, err := other.GetW("/e")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(srv.Sessions()) != 2 || !srv.ExpireSession(conn.SessionID()) {
+		t.Fatal(srv.Sessions())
+	}
+	waitEvent(t, ch, zk.EventNodeDeleted)
+
+	expired := false
+	timeout := time.After(time.Second * 3)
+	for !expired {
+		select {
+		case ev := <-events:
+			if ev.State == zk.StateExpired {
+				expired = true
+			}
+		case <-timeout:
+			t.Fatal("wait expired timeout")
+		}
+	}
+	for ev := range events {
+		if ev.State == zk.StateHasSession {
+			break
+		}
+	}
+	if ok, _, err := conn.Exists("/e"); ok || err != nil {
+		t.Fatal(ok, err)
+	}
+}
+
+func TestSessionTimeout(t *testing.T) {
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	other, _ := connect(t, srv)
+	defer other.Close()
+
+	// raw connection closed without close request
+	nc, err := net.Dial("tcp", srv.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &encoder{}
+	e.int32(protocolVersion)
+	e.int64(0)
+	e.int32(200) // session timeout ms
+	e.int64(0)
+	e.bytes(make([]byte, 16))
+	nc.Write(e.packet())
+	e = &encoder{}
+	e.int32(1)
+	e.int32(opCreate)
+	e.string("/e")
+	e.bytes(nil)
+	e.acls(zk.WorldACL(zk.PermAll))
+	e.int32(zk.FlagEphemeral)
+	nc.Write(e.packet())
+	time.Sleep(time.Millisecond * 100)
+	if ok, _, _ := other.Exists("/e"); !ok {
+		t.Fatal("ephemeral node not created")
+	}
+
+	nc.Close()
+	time.Sleep(time.Millisecond * 100)
+	if ok, _, _ := other.Exists("/e"); !ok {
+		t.Fatal("ephemeral node deleted before session timeout")
+	}
+	time.Sleep(time.Millisecond * 300)
+	if ok, _, _ := other.Exists("/e"); ok {
+		t.Fatal("ephemeral node not deleted after session timeout")
+	}
+}
+
+func TestMulti(t *testing.T) {
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	conn, _ := connect(t, srv)
+	defer conn.Close()
+	acl := zk.WorldACL(zk.PermAll)
+
+	res, err := conn.Multi(
+		&zk.CreateRequest{Path: "/m", Data: []byte("1"), Acl: acl},
+		&zk.SetDataRequest{Path: "/m", Data: []byte("2"), Version: 0},
+		&zk.CheckVersionRequest{Path: "/m", Version: 1},
+	)
+	if err != nil || len(res) != 3 || res[0].String != "/m" || res[1].Stat.Version != 1 {
+		t.Fatal(res, err)
+	}
+
+	res, err = conn.Multi(
+		&zk.CreateRequest{Path: "/m/c", Acl: acl},
+		&zk.DeleteRequest{Path: "/m", Version: 0},
+		&zk.CreateRequest{Path: "/n", Acl: acl},
+	)
+	if err != zk.ErrBadVersion || len(res) != 3 || res[1].Error != zk.ErrBadVersion {
+		t.Fatal(res, err)
+	}
+	if ok, _, _ := conn.Exists("/m/c"); ok {
+		t.Fatal("multi not rolled back")
+	}
+}
+
+func TestACL(t *testing.T) {
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	conn, _ := connect(t, srv)
+	defer conn.Close()
+	other, _ := connect(t, srv)
+	defer other.Close()
+
+	if err := conn.AddAuth("digest", []byte("user:pass")); err != nil {
+		t.Fatal(err)
+	}
+	acl := zk.DigestACL(zk.PermAll, "user", "pass")
+	acl = append(acl, zk.WorldACL(zk.PermRead)...)
+	if _, err := conn.Create("/acl", nil, 0, acl); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Create("/auth", nil, 0, zk.AuthACL(zk.PermAll)); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, err := conn.GetACL("/auth"); err != nil || len(got) != 1 || got[0] != acl[0] {
+		t.Fatal(got, err)
+	}
+
+	if _, _, err := other.Get("/acl"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Set("/acl", nil, -1); err != zk.ErrNoAuth {
+		t.Fatal(err)
+	}
+	if _, _, err := other.Get("/auth"); err != zk.ErrNoAuth {
+		t.Fatal(err)
+	}
+	if _, err := other.Create("/x", nil, 0, zk.AuthACL(zk.PermAll)); err != zk.ErrInvalidACL {
+		t.Fatal(err)
+	}
+	if _, err := conn.Set("/acl", []byte("1"), -1); err != nil {
+		t.Fatal(err)
+	}
+}